@@ -86,6 +86,13 @@ static_resources:
                       "@type": type.googleapis.com/envoy.extensions.filters.http.ext_proc.v3.ExternalProcessor
                       failure_mode_allow: false
                       message_timeout: 30s
+                      # lets the processor switch event stream responses to STREAMED body mode
+                      allow_mode_override: true
+                      # per-route settings, see Route Configuration in the README
+                      request_attributes: ["xds.route_name", "xds.route_metadata"]
+                      metadata_options:
+                        forwarding_namespaces:
+                          untyped: ["inferno"]
                       processing_mode:
                         request_header_mode: SEND
                         request_body_mode: BUFFERED
@@ -0,0 +1,382 @@
+// Package config holds the typed Inferno configuration, loaded from a YAML or JSON file with
+// environment variables as overrides.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"sigs.k8s.io/yaml"
+)
+
+type Config struct {
+	Server ServerConfig `json:"server"`
+	// Filters are the processor filters to run, in order
+	Filters       []string            `json:"filters"`
+	SemanticCache SemanticCacheConfig `json:"semantic_cache"`
+	Embedding     EmbeddingConfig     `json:"embedding"`
+	PromptGuard   PromptGuardConfig   `json:"prompt_guard"`
+	Logging       LoggingConfig       `json:"logging"`
+}
+
+type ServerConfig struct {
+	ExtProcPort int `json:"ext_proc_port"`
+	// MetricsPort serves Prometheus metrics on /metrics and the /healthz and /readyz probes, 0 disables it
+	MetricsPort int `json:"metrics_port"`
+	// HealthCheckInterval is how often the dependencies are checked for the health service
+	HealthCheckInterval Duration `json:"health_check_interval"`
+	// ShutdownTimeout bounds how long streams in flight may drain on shutdown before they are closed
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+}
+
+type SemanticCacheConfig struct {
+	SimilarityThreshold float64 `json:"similarity_threshold"`
+	// Index is the vector index of the memory store, hnsw or flat
+	Index string `json:"index"`
+	// Store is where cached responses are kept, memory or redis
+	Store string      `json:"store"`
+	Redis RedisConfig `json:"redis"`
+	CacheLimits
+	SweepInterval Duration       `json:"sweep_interval"`
+	Scope         ScopeConfig    `json:"scope"`
+	Snapshot      SnapshotConfig `json:"snapshot"`
+}
+
+type RedisConfig struct {
+	URL    string `json:"url"`
+	Prefix string `json:"prefix"`
+	Index  string `json:"index"`
+}
+
+// CacheLimits bound a cache, zero values mean unbounded
+type CacheLimits struct {
+	TTL        Duration `json:"ttl"`
+	MaxEntries int      `json:"max_entries"`
+	MaxBytes   int64    `json:"max_bytes"`
+	// Eviction is lru, lfu or fifo
+	Eviction string `json:"eviction"`
+}
+
+type ScopeConfig struct {
+	Fields  []string `json:"fields"`
+	Headers []string `json:"headers"`
+}
+
+type SnapshotConfig struct {
+	// Path is the snapshot file, empty disables snapshots
+	Path     string   `json:"path"`
+	Interval Duration `json:"interval"`
+}
+
+type EmbeddingConfig struct {
+	Provider     string      `json:"provider"`
+	URL          string      `json:"url"`
+	Host         string      `json:"host"`
+	Model        string      `json:"model"`
+	APIKey       string      `json:"api_key"`
+	InputName    string      `json:"input_name"`
+	Dimensions   int         `json:"dimensions"`
+	Timeout      Duration    `json:"timeout"`
+	BatchWindow  Duration    `json:"batch_window"`
+	BatchMaxSize int         `json:"batch_max_size"`
+	Cache        CacheLimits `json:"cache"`
+}
+
+type PromptGuardConfig struct {
+	URL           string `json:"url"`
+	APIKey        string `json:"api_key"`
+	CheckPrompt   bool   `json:"check_prompt"`
+	CheckResponse bool   `json:"check_response"`
+	// StreamCheckInterval is how many bytes of generated output an event stream forwards between response checks,
+	// a risky stream is cut at the next check. 0 checks streams only once they ended, when the whole output has
+	// already been delivered and a risky stream can only be flagged.
+	StreamCheckInterval int `json:"stream_check_interval"`
+	// Risks are the Granite Guardian risks checked, per direction
+	Risks GuardRisksConfig `json:"risks"`
+	// OnFailure is what happens when the guardian gives no verdict, per direction
+	OnFailure GuardFailureConfig `json:"on_failure"`
+	// FallbackPatterns are the regular expressions of the local detector used by the degrade policy
+	FallbackPatterns []string `json:"fallback_patterns"`
+	// Backends judge the texts, a single Granite Guardian at url when empty
+	Backends []GuardBackendConfig `json:"backends"`
+	// Voting combines the verdicts of several backends: any, all or majority
+	Voting string `json:"voting"`
+}
+
+// GuardBackendConfig configures a guard backend, only read at startup
+type GuardBackendConfig struct {
+	// Type is granite-guardian, llama-guard, openai-moderation or rules
+	Type string `json:"type"`
+	// Name labels the backend in logs and metrics, the type by default
+	Name string `json:"name"`
+	// URL is the OpenAI compatible base URL of the model backends, such as http://llama-guard/v1
+	URL    string `json:"url"`
+	APIKey string `json:"api_key"`
+	Model  string `json:"model"`
+	// Rules are the rules of the rules backend
+	Rules []GuardRuleConfig `json:"rules"`
+}
+
+// GuardRuleConfig is a rule of the rules backend, flagging texts that match its pattern or contain one of
+// its keywords
+type GuardRuleConfig struct {
+	Name string `json:"name"`
+	// Pattern is a regular expression
+	Pattern string `json:"pattern"`
+	// Keywords are matched as whole words or phrases, ignoring case
+	Keywords []string `json:"keywords"`
+}
+
+// GuardRisksConfig lists the risks checked in each direction, see PromptRisks and ResponseRisks
+type GuardRisksConfig struct {
+	Prompt   []string `json:"prompt"`
+	Response []string `json:"response"`
+}
+
+// PromptRisks are the Granite Guardian risks that can be checked on prompts
+var PromptRisks = []string{"harm", "social_bias", "jailbreak", "violence", "profanity", "unethical_behavior"}
+
+// ResponseRisks are the risks that can be checked on responses, the prompt risks and those judging the
+// response against the request: its context, its question or its tools
+var ResponseRisks = append(PromptRisks[:len(PromptRisks):len(PromptRisks)], "groundedness", "answer_relevance", "function_call")
+
+// GuardFailureConfig holds the failure policy of each direction: open allows the text, closed answers
+// 503 and degrade falls back to the local detector
+type GuardFailureConfig struct {
+	Prompt   string `json:"prompt"`
+	Response string `json:"response"`
+}
+
+type LoggingConfig struct {
+	// Format is text or json
+	Format string `json:"format"`
+	// Level is debug, info, warn or error
+	Level string `json:"level"`
+	// Redact is how prompts and generated text are logged: none, truncate or hash
+	Redact string `json:"redact"`
+}
+
+// Duration is a time.Duration written as a Go duration string such as 5m, or a number of seconds
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(v * float64(time.Second))
+	case string:
+		parsed, err := parseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	return nil
+}
+
+// parseDuration accepts a Go duration or whole seconds
+func parseDuration(s string) (time.Duration, error) {
+	if secs, err := strconv.Atoi(s); err == nil {
+		return time.Duration(secs) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+func Default() *Config {
+	cacheLimits := CacheLimits{
+		TTL:        Duration(24 * time.Hour),
+		MaxEntries: 10000,
+		Eviction:   "lru",
+	}
+	return &Config{
+		Server:  ServerConfig{ExtProcPort: 50051, MetricsPort: 9090, HealthCheckInterval: Duration(10 * time.Second), ShutdownTimeout: Duration(30 * time.Second)},
+		Filters: []string{"prompt-guard", "semantic-cache", "token-metrics"},
+		SemanticCache: SemanticCacheConfig{
+			SimilarityThreshold: 0.75,
+			Index:               "hnsw",
+			Store:               "memory",
+			Redis:               RedisConfig{Prefix: "inferno:cache:", Index: "inferno-semantic-cache"},
+			CacheLimits:         cacheLimits,
+			SweepInterval:       Duration(time.Minute),
+			Scope: ScopeConfig{
+				Fields:  []string{"model", "temperature", "max_tokens", "tools", "response_format"},
+				Headers: []string{":path", "authorization"},
+			},
+			Snapshot: SnapshotConfig{Interval: Duration(5 * time.Minute)},
+		},
+		Embedding: EmbeddingConfig{
+			InputName:    "text",
+			Dimensions:   512,
+			Timeout:      Duration(10 * time.Second),
+			BatchWindow:  Duration(5 * time.Millisecond),
+			BatchMaxSize: 32,
+			Cache:        cacheLimits,
+		},
+		PromptGuard: PromptGuardConfig{
+			CheckPrompt:         true,
+			CheckResponse:       true,
+			StreamCheckInterval: 1000,
+			Risks:               GuardRisksConfig{Prompt: []string{"harm"}, Response: []string{"harm"}},
+			OnFailure:           GuardFailureConfig{Prompt: "open", Response: "open"},
+			Voting:              "any",
+			FallbackPatterns: []string{
+				`(?i)\b(ignore|disregard|forget)\b.{0,20}\b(previous|prior|above|earlier)\b.{0,20}\b(instructions|prompts?|rules)\b`,
+				`(?i)\b(reveal|print|show|repeat)\b.{0,20}\b(system prompt|hidden instructions)\b`,
+				`(?i)\b(jailbreak|DAN mode|developer mode)\b`,
+				`(?i)\bhow (do i|to|can i)\b.{0,30}\b(make|build|synthesi[sz]e)\b.{0,20}\b(bomb|explosives?|nerve agent|meth)\b`,
+			},
+		},
+		Logging: LoggingConfig{Format: "text", Level: "info", Redact: "none"},
+	}
+}
+
+// Load reads the configuration file at path over the defaults, applies the environment overrides and
+// validates the result. An empty path only uses the defaults and the environment.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading config: %w", err)
+		}
+		// YAML is a superset of JSON, so this reads both
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	}
+	if err := errors.Join(applyEnv(cfg, os.LookupEnv)...); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// FromEnv returns the defaults with the environment overrides, invalid values are reported and skipped
+// so this never fails
+func FromEnv() (*Config, []error) {
+	cfg := Default()
+	errs := applyEnv(cfg, os.LookupEnv)
+	return cfg, errs
+}
+
+// Validate reports every invalid setting
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, field, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+		}
+	}
+	oneOf := func(field, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		check(false, field, "must be one of %v, got %q", allowed, value)
+	}
+	limits := func(prefix string, l CacheLimits) {
+		check(l.TTL >= 0, prefix+"ttl", "must not be negative")
+		check(l.MaxEntries >= 0, prefix+"max_entries", "must not be negative")
+		check(l.MaxBytes >= 0, prefix+"max_bytes", "must not be negative")
+		oneOf(prefix+"eviction", l.Eviction, "lru", "lfu", "fifo")
+	}
+
+	check(c.Server.ExtProcPort > 0 && c.Server.ExtProcPort < 65536, "server.ext_proc_port", "must be a port number, got %d", c.Server.ExtProcPort)
+	check(c.Server.MetricsPort >= 0 && c.Server.MetricsPort < 65536, "server.metrics_port", "must be a port number or 0, got %d", c.Server.MetricsPort)
+	check(c.Server.MetricsPort != c.Server.ExtProcPort, "server.metrics_port", "must differ from server.ext_proc_port")
+	check(c.Server.HealthCheckInterval > 0, "server.health_check_interval", "must be positive")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout", "must be positive")
+
+	seen := map[string]bool{}
+	for _, f := range c.Filters {
+		oneOf("filters", f, "prompt-guard", "semantic-cache", "token-metrics")
+		check(!seen[f], "filters", "%q is listed twice", f)
+		seen[f] = true
+		// a cache ahead of the guard would answer unchecked prompts and store blocked responses
+		check(f != "prompt-guard" || !seen["semantic-cache"], "filters", "prompt-guard must run before semantic-cache")
+	}
+
+	sc := c.SemanticCache
+	check(sc.SimilarityThreshold >= 0 && sc.SimilarityThreshold <= 1, "semantic_cache.similarity_threshold", "must be between 0 and 1, got %v", sc.SimilarityThreshold)
+	oneOf("semantic_cache.index", sc.Index, "hnsw", "flat")
+	oneOf("semantic_cache.store", sc.Store, "memory", "redis")
+	check(sc.Store != "redis" || sc.Redis.URL != "", "semantic_cache.redis.url", "is required with the redis store")
+	limits("semantic_cache.", sc.CacheLimits)
+	check(sc.SweepInterval >= 0, "semantic_cache.sweep_interval", "must not be negative")
+	check(sc.Snapshot.Interval >= 0, "semantic_cache.snapshot.interval", "must not be negative")
+
+	e := c.Embedding
+	if e.Provider != "" {
+		oneOf("embedding.provider", e.Provider, "local", "kserve-v1", "kserve-v2", "openai", "tei")
+	}
+	check(e.Provider == "" || e.Provider == "local" || e.URL != "", "embedding.url", "is required with the %s provider", e.Provider)
+	check(e.Dimensions > 0, "embedding.dimensions", "must be positive, got %d", e.Dimensions)
+	check(e.Timeout > 0, "embedding.timeout", "must be positive")
+	check(e.BatchWindow >= 0, "embedding.batch_window", "must not be negative")
+	check(e.BatchMaxSize > 0, "embedding.batch_max_size", "must be positive, got %d", e.BatchMaxSize)
+	limits("embedding.cache.", e.Cache)
+
+	pg := c.PromptGuard
+	check(len(pg.Risks.Prompt) > 0, "prompt_guard.risks.prompt", "must list at least one risk")
+	check(len(pg.Risks.Response) > 0, "prompt_guard.risks.response", "must list at least one risk")
+	for _, r := range pg.Risks.Prompt {
+		oneOf("prompt_guard.risks.prompt", r, PromptRisks...)
+	}
+	for _, r := range pg.Risks.Response {
+		oneOf("prompt_guard.risks.response", r, ResponseRisks...)
+	}
+	oneOf("prompt_guard.on_failure.prompt", pg.OnFailure.Prompt, "open", "closed", "degrade")
+	oneOf("prompt_guard.on_failure.response", pg.OnFailure.Response, "open", "closed", "degrade")
+	for _, p := range pg.FallbackPatterns {
+		_, err := regexp.Compile(p)
+		check(err == nil, "prompt_guard.fallback_patterns", "%v", err)
+	}
+	oneOf("prompt_guard.voting", pg.Voting, "any", "all", "majority")
+	check(pg.StreamCheckInterval >= 0, "prompt_guard.stream_check_interval", "must not be negative, got %d", pg.StreamCheckInterval)
+	for i, b := range pg.Backends {
+		field := fmt.Sprintf("prompt_guard.backends[%d]", i)
+		oneOf(field+".type", b.Type, "granite-guardian", "llama-guard", "openai-moderation", "rules")
+		check(b.Type == "rules" || b.URL != "", field+".url", "is required with the %s backend", b.Type)
+		check(b.Type != "rules" || len(b.Rules) > 0, field+".rules", "must list at least one rule")
+		if b.Type == "openai-moderation" && b.Model != "" {
+			oneOf(field+".model", b.Model, "omni-moderation-latest", "omni-moderation-2024-09-26",
+				"text-moderation-latest", "text-moderation-stable")
+		}
+		for j, r := range b.Rules {
+			rule := fmt.Sprintf("%s.rules[%d]", field, j)
+			check(r.Name != "", rule+".name", "is required")
+			check(r.Pattern != "" || len(r.Keywords) > 0, rule, "needs a pattern or keywords")
+			if r.Pattern != "" {
+				_, err := regexp.Compile(r.Pattern)
+				check(err == nil, rule+".pattern", "%v", err)
+			}
+		}
+	}
+
+	oneOf("logging.format", c.Logging.Format, "text", "json")
+	oneOf("logging.level", c.Logging.Level, "debug", "info", "warn", "error")
+	oneOf("logging.redact", c.Logging.Redact, "none", "truncate", "hash")
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,233 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kuadrant/inferno/internal/config"
+)
+
+var _ = Describe("Load", func() {
+	var dir string
+
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		Expect(os.WriteFile(path, []byte(content), 0o644)).To(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		for _, name := range []string{"EXT_PROC_PORT", "SIMILARITY_THRESHOLD", "PROCESSOR_FILTERS", "SEMANTIC_CACHE_TTL", "DISABLE_PROMPT_RISK_CHECK", "LOG_LEVEL", "LOG_REDACT", "PROMPT_RISK_CHECK_ON_FAILURE", "RESPONSE_RISK_CHECK_ON_FAILURE", "GUARDIAN_PROMPT_RISKS", "GUARDIAN_RESPONSE_RISKS"} {
+			GinkgoT().Setenv(name, "")
+			os.Unsetenv(name)
+		}
+	})
+
+	It("should use the defaults without a file", func() {
+		cfg, err := config.Load("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg).To(Equal(config.Default()))
+	})
+
+	It("should read YAML over the defaults", func() {
+		cfg, err := config.Load(write("inferno.yaml", `
+server:
+  ext_proc_port: 9000
+  health_check_interval: 30s
+filters: [semantic-cache]
+semantic_cache:
+  similarity_threshold: 0.9
+  ttl: 1h
+  scope:
+    headers: [x-tenant]
+prompt_guard:
+  check_response: false
+`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Server.ExtProcPort).To(Equal(9000))
+		Expect(time.Duration(cfg.Server.HealthCheckInterval)).To(Equal(30 * time.Second))
+		Expect(cfg.Filters).To(Equal([]string{"semantic-cache"}))
+		Expect(cfg.SemanticCache.SimilarityThreshold).To(Equal(0.9))
+		Expect(time.Duration(cfg.SemanticCache.TTL)).To(Equal(time.Hour))
+		Expect(cfg.SemanticCache.Scope.Headers).To(Equal([]string{"x-tenant"}))
+		Expect(cfg.SemanticCache.Scope.Fields).To(Equal(config.Default().SemanticCache.Scope.Fields))
+		Expect(cfg.PromptGuard.CheckPrompt).To(BeTrue())
+		Expect(cfg.PromptGuard.CheckResponse).To(BeFalse())
+	})
+
+	It("should read JSON", func() {
+		cfg, err := config.Load(write("inferno.json", `{"embedding": {"provider": "local", "dimensions": 64, "timeout": 3}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Embedding.Dimensions).To(Equal(64))
+		Expect(time.Duration(cfg.Embedding.Timeout)).To(Equal(3 * time.Second))
+	})
+
+	It("should let environment variables override the file", func() {
+		GinkgoT().Setenv("SIMILARITY_THRESHOLD", "0.8")
+		GinkgoT().Setenv("PROCESSOR_FILTERS", "")
+		GinkgoT().Setenv("DISABLE_PROMPT_RISK_CHECK", "yes")
+		cfg, err := config.Load(write("inferno.yaml", "semantic_cache:\n  similarity_threshold: 0.9\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.SemanticCache.SimilarityThreshold).To(Equal(0.8))
+		Expect(cfg.Filters).To(BeEmpty())
+		Expect(cfg.PromptGuard.CheckPrompt).To(BeFalse())
+	})
+
+	It("should read the logging settings from the environment", func() {
+		GinkgoT().Setenv("LOG_LEVEL", "DEBUG")
+		GinkgoT().Setenv("LOG_REDACT", "hash")
+		cfg, err := config.Load(write("inferno.yaml", "logging:\n  format: json\n  redact: truncate\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.Logging).To(Equal(config.LoggingConfig{Format: "json", Level: "debug", Redact: "hash"}))
+
+		GinkgoT().Setenv("LOG_REDACT", "mask")
+		_, err = config.Load("")
+		Expect(err).To(MatchError(ContainSubstring("logging.redact:")))
+	})
+
+	It("should read the guard failure policies", func() {
+		GinkgoT().Setenv("RESPONSE_RISK_CHECK_ON_FAILURE", "Closed")
+		cfg, err := config.Load(write("inferno.yaml", "prompt_guard:\n  on_failure:\n    prompt: degrade\n  fallback_patterns: ['(?i)jailbreak']\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.PromptGuard.OnFailure).To(Equal(config.GuardFailureConfig{Prompt: "degrade", Response: "closed"}))
+		Expect(cfg.PromptGuard.FallbackPatterns).To(Equal([]string{"(?i)jailbreak"}))
+
+		_, err = config.Load(write("inferno.yaml", "prompt_guard:\n  on_failure:\n    prompt: ignore\n  fallback_patterns: ['(unclosed']\n"))
+		Expect(err).To(MatchError(ContainSubstring("prompt_guard.on_failure.prompt:")))
+		Expect(err).To(MatchError(ContainSubstring("prompt_guard.fallback_patterns:")))
+	})
+
+	It("should read the guardian risks", func() {
+		GinkgoT().Setenv("GUARDIAN_RESPONSE_RISKS", "harm,groundedness")
+		cfg, err := config.Load(write("inferno.yaml", "prompt_guard:\n  risks:\n    prompt: [jailbreak, harm]\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.PromptGuard.Risks).To(Equal(config.GuardRisksConfig{Prompt: []string{"jailbreak", "harm"}, Response: []string{"harm", "groundedness"}}))
+
+		_, err = config.Load(write("inferno.yaml", "prompt_guard:\n  risks:\n    prompt: [groundedness]\n"))
+		Expect(err).To(MatchError(ContainSubstring("prompt_guard.risks.prompt:")))
+	})
+
+	It("should read the stream check interval", func() {
+		GinkgoT().Setenv("RESPONSE_RISK_CHECK_STREAM_INTERVAL", "0")
+		cfg, err := config.Load(write("inferno.yaml", "prompt_guard:\n  stream_check_interval: 500\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.PromptGuard.StreamCheckInterval).To(BeZero())
+
+		GinkgoT().Setenv("RESPONSE_RISK_CHECK_STREAM_INTERVAL", "")
+		_, err = config.Load(write("inferno.yaml", "prompt_guard:\n  stream_check_interval: -1\n"))
+		Expect(err).To(MatchError(ContainSubstring("prompt_guard.stream_check_interval:")))
+	})
+
+	It("should read the guard backends", func() {
+		GinkgoT().Setenv("GUARD_VOTING", "Majority")
+		cfg, err := config.Load(write("inferno.yaml", "prompt_guard:\n  backends:\n"+
+			"  - type: llama-guard\n    url: http://llama-guard/v1\n"+
+			"  - type: rules\n    rules:\n    - name: secrets\n      pattern: 'AKIA[0-9A-Z]{16}'\n    - name: weapons\n      keywords: [bomb]\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cfg.PromptGuard.Voting).To(Equal("majority"))
+		Expect(cfg.PromptGuard.Backends).To(Equal([]config.GuardBackendConfig{
+			{Type: "llama-guard", URL: "http://llama-guard/v1"},
+			{Type: "rules", Rules: []config.GuardRuleConfig{{Name: "secrets", Pattern: "AKIA[0-9A-Z]{16}"}, {Name: "weapons", Keywords: []string{"bomb"}}}},
+		}))
+
+		_, err = config.Load(write("inferno.yaml", "prompt_guard:\n  backends:\n"+
+			"  - type: perspective\n  - type: openai-moderation\n    model: gpt-4o\n  - type: rules\n    rules:\n    - pattern: '(unclosed'\n"))
+		Expect(err).To(MatchError(ContainSubstring("prompt_guard.backends[0].type:")))
+		Expect(err).To(MatchError(ContainSubstring("prompt_guard.backends[1].url:")))
+		Expect(err).To(MatchError(ContainSubstring("prompt_guard.backends[1].model:")))
+		Expect(err).To(MatchError(ContainSubstring("prompt_guard.backends[2].rules[0].name:")))
+		Expect(err).To(MatchError(ContainSubstring("prompt_guard.backends[2].rules[0].pattern:")))
+	})
+
+	It("should run the guard before the cache", func() {
+		_, err := config.Load(write("inferno.yaml", "filters: [semantic-cache, prompt-guard]\n"))
+		Expect(err).To(MatchError(ContainSubstring("prompt-guard must run before semantic-cache")))
+
+		_, err = config.Load(write("inferno.yaml", "filters: [semantic-cache, token-metrics]\n"))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should reject unknown fields", func() {
+		_, err := config.Load(write("inferno.yaml", "semantic_cache:\n  similarity: 0.9\n"))
+		Expect(err).To(MatchError(ContainSubstring(`unknown field "similarity"`)))
+	})
+
+	It("should report every invalid setting", func() {
+		GinkgoT().Setenv("SEMANTIC_CACHE_TTL", "forever")
+		_, err := config.Load(write("inferno.yaml", `
+server:
+  health_check_interval: 0s
+filters: [semantic-cache, guard]
+semantic_cache:
+  similarity_threshold: 1.5
+  store: redis
+embedding:
+  provider: openai
+`))
+		Expect(err).To(MatchError(ContainSubstring("SEMANTIC_CACHE_TTL")))
+
+		GinkgoT().Setenv("SEMANTIC_CACHE_TTL", "")
+		_, err = config.Load(filepath.Join(dir, "inferno.yaml"))
+		Expect(err).To(HaveOccurred())
+		for _, field := range []string{"server.health_check_interval", "filters", "semantic_cache.similarity_threshold", "semantic_cache.redis.url", "embedding.url"} {
+			Expect(err.Error()).To(ContainSubstring(field + ":"))
+		}
+	})
+})
+
+var _ = Describe("Watcher", func() {
+	var path string
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "inferno.yaml")
+		Expect(os.WriteFile(path, []byte("semantic_cache:\n  similarity_threshold: 0.8\n"), 0o644)).To(Succeed())
+	})
+
+	It("should swap in a reloaded configuration and notify listeners", func() {
+		w, err := config.NewWatcher(path)
+		Expect(err).NotTo(HaveOccurred())
+		var notified *config.Config
+		w.OnChange(func(cfg *config.Config) { notified = cfg })
+
+		Expect(os.WriteFile(path, []byte("semantic_cache:\n  similarity_threshold: 0.95\n"), 0o644)).To(Succeed())
+		Expect(w.Reload()).To(Succeed())
+		Expect(w.Current().SemanticCache.SimilarityThreshold).To(Equal(0.95))
+		Expect(notified).To(BeIdenticalTo(w.Current()))
+	})
+
+	It("should keep the current configuration when the reload is invalid", func() {
+		w, err := config.NewWatcher(path)
+		Expect(err).NotTo(HaveOccurred())
+		current := w.Current()
+		w.OnChange(func(*config.Config) { Fail("listener called for an invalid configuration") })
+
+		Expect(os.WriteFile(path, []byte("semantic_cache:\n  similarity_threshold: 2\n"), 0o644)).To(Succeed())
+		Expect(w.Reload()).To(MatchError(ContainSubstring("similarity_threshold")))
+		Expect(w.Current()).To(BeIdenticalTo(current))
+	})
+})
+
+var _ = Describe("RestartRequired", func() {
+	It("should ignore settings applied live", func() {
+		next := config.Default()
+		next.Filters = []string{"token-metrics"}
+		next.SemanticCache.SimilarityThreshold = 0.9
+		next.PromptGuard.CheckPrompt = false
+		next.Logging.Level, next.Logging.Redact = "debug", "hash"
+		Expect(config.RestartRequired(config.Default(), next)).To(BeEmpty())
+	})
+
+	It("should list sections with startup settings changed", func() {
+		next := config.Default()
+		next.Server.ExtProcPort = 9000
+		next.SemanticCache.Store = "redis"
+		next.PromptGuard.URL = "http://guardian"
+		next.Logging.Format = "json"
+		Expect(config.RestartRequired(config.Default(), next)).To(Equal([]string{"server", "semantic_cache", "prompt_guard", "logging"}))
+	})
+})
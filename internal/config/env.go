@@ -0,0 +1,144 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// lookupFunc matches os.LookupEnv
+type lookupFunc func(string) (string, bool)
+
+// applyEnv overrides cfg with the environment variables that are set. Empty values are ignored,
+// except for the comma separated lists where an empty value clears the list.
+func applyEnv(cfg *Config, lookup lookupFunc) []error {
+	var errs []error
+	str := func(name string, dst *string) {
+		if v, _ := lookup(name); v != "" {
+			*dst = v
+		}
+	}
+	lower := func(name string, dst *string) {
+		if v, _ := lookup(name); v != "" {
+			*dst = strings.ToLower(strings.TrimSpace(v))
+		}
+	}
+	list := func(name string, dst *[]string) {
+		if v, ok := lookup(name); ok {
+			*dst = splitList(v)
+		}
+	}
+	integer := func(name string, dst *int) {
+		if v, _ := lookup(name); v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid integer %q", name, v))
+				return
+			}
+			*dst = i
+		}
+	}
+	integer64 := func(name string, dst *int64) {
+		if v, _ := lookup(name); v != "" {
+			i, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid integer %q", name, v))
+				return
+			}
+			*dst = i
+		}
+	}
+	float := func(name string, dst *float64) {
+		if v, _ := lookup(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid number %q", name, v))
+				return
+			}
+			*dst = f
+		}
+	}
+	duration := func(name string, dst *Duration) {
+		if v, _ := lookup(name); v != "" {
+			d, err := parseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				return
+			}
+			*dst = Duration(d)
+		}
+	}
+	disable := func(name string, dst *bool) {
+		if v, _ := lookup(name); v == "yes" {
+			*dst = false
+		}
+	}
+	limits := func(prefix string, l *CacheLimits) {
+		duration(prefix+"_TTL", &l.TTL)
+		integer(prefix+"_MAX_ENTRIES", &l.MaxEntries)
+		integer64(prefix+"_MAX_BYTES", &l.MaxBytes)
+		lower(prefix+"_EVICTION", &l.Eviction)
+	}
+
+	integer("EXT_PROC_PORT", &cfg.Server.ExtProcPort)
+	integer("METRICS_PORT", &cfg.Server.MetricsPort)
+	duration("HEALTH_CHECK_INTERVAL", &cfg.Server.HealthCheckInterval)
+	duration("SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+	list("PROCESSOR_FILTERS", &cfg.Filters)
+
+	sc := &cfg.SemanticCache
+	float("SIMILARITY_THRESHOLD", &sc.SimilarityThreshold)
+	str("SEMANTIC_CACHE_INDEX", &sc.Index)
+	str("SEMANTIC_CACHE_STORE", &sc.Store)
+	str("SEMANTIC_CACHE_REDIS_URL", &sc.Redis.URL)
+	str("SEMANTIC_CACHE_REDIS_PREFIX", &sc.Redis.Prefix)
+	str("SEMANTIC_CACHE_REDIS_INDEX", &sc.Redis.Index)
+	limits("SEMANTIC_CACHE", &sc.CacheLimits)
+	duration("SEMANTIC_CACHE_SWEEP_INTERVAL", &sc.SweepInterval)
+	list("SEMANTIC_CACHE_SCOPE_FIELDS", &sc.Scope.Fields)
+	list("SEMANTIC_CACHE_SCOPE_HEADERS", &sc.Scope.Headers)
+	str("SEMANTIC_CACHE_SNAPSHOT_PATH", &sc.Snapshot.Path)
+	duration("SEMANTIC_CACHE_SNAPSHOT_INTERVAL", &sc.Snapshot.Interval)
+
+	e := &cfg.Embedding
+	str("EMBEDDING_PROVIDER", &e.Provider)
+	str("EMBEDDING_MODEL_SERVER", &e.URL)
+	str("EMBEDDING_MODEL_HOST", &e.Host)
+	str("EMBEDDING_MODEL_NAME", &e.Model)
+	str("EMBEDDING_API_KEY", &e.APIKey)
+	str("EMBEDDING_INPUT_NAME", &e.InputName)
+	integer("EMBEDDING_DIMENSIONS", &e.Dimensions)
+	duration("EMBEDDING_TIMEOUT", &e.Timeout)
+	duration("EMBEDDING_BATCH_WINDOW", &e.BatchWindow)
+	integer("EMBEDDING_BATCH_MAX_SIZE", &e.BatchMaxSize)
+	limits("EMBEDDING_CACHE", &e.Cache)
+
+	pg := &cfg.PromptGuard
+	str("GUARDIAN_URL", &pg.URL)
+	str("GUARDIAN_API_KEY", &pg.APIKey)
+	disable("DISABLE_PROMPT_RISK_CHECK", &pg.CheckPrompt)
+	disable("DISABLE_RESPONSE_RISK_CHECK", &pg.CheckResponse)
+	integer("RESPONSE_RISK_CHECK_STREAM_INTERVAL", &pg.StreamCheckInterval)
+	list("GUARDIAN_PROMPT_RISKS", &pg.Risks.Prompt)
+	list("GUARDIAN_RESPONSE_RISKS", &pg.Risks.Response)
+	lower("GUARD_VOTING", &pg.Voting)
+	lower("PROMPT_RISK_CHECK_ON_FAILURE", &pg.OnFailure.Prompt)
+	lower("RESPONSE_RISK_CHECK_ON_FAILURE", &pg.OnFailure.Response)
+
+	lower("LOG_FORMAT", &cfg.Logging.Format)
+	lower("LOG_LEVEL", &cfg.Logging.Level)
+	lower("LOG_REDACT", &cfg.Logging.Redact)
+
+	return errs
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(s string) []string {
+	out := []string{}
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
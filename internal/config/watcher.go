@@ -0,0 +1,120 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// Watcher holds the current configuration and reloads it from its file on SIGHUP. A reload that
+// fails to load or validate is logged and the current configuration is kept.
+type Watcher struct {
+	path    string
+	current atomic.Pointer[Config]
+
+	mu        sync.Mutex
+	listeners []func(*Config)
+}
+
+// NewWatcher loads the configuration at path, see Load
+func NewWatcher(path string) (*Watcher, error) {
+	cfg, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{path: path}
+	w.current.Store(cfg)
+	return w, nil
+}
+
+// Current returns the configuration in effect, callers must not modify it
+func (w *Watcher) Current() *Config {
+	return w.current.Load()
+}
+
+// OnChange registers fn to be called with each configuration successfully reloaded
+func (w *Watcher) OnChange(fn func(*Config)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.listeners = append(w.listeners, fn)
+}
+
+// Reload loads the configuration again and swaps it in
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	cfg, err := Load(w.path)
+	if err != nil {
+		return err
+	}
+	old := w.current.Swap(cfg)
+	if changed := RestartRequired(old, cfg); len(changed) > 0 {
+		slog.Warn("Some changes only take effect after a restart", "component", "config", "sections", changed)
+	}
+	for _, fn := range w.listeners {
+		fn(cfg)
+	}
+	slog.Info("Reloaded configuration", "component", "config", "path", w.path)
+	return nil
+}
+
+// Run reloads the configuration on every SIGHUP until ctx is done
+func (w *Watcher) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("Received SIGHUP, reloading configuration", "component", "config")
+			if err := w.Reload(); err != nil {
+				slog.Error("Reload failed, keeping the current configuration", "component", "config", "error", err)
+			}
+		}
+	}
+}
+
+// RestartRequired lists the sections that differ between old and new in settings that are only read at
+// startup. The filter list, similarity threshold, cache scope, guard checks, risks and failure policies
+// apply to new requests live, as do the log level and redaction.
+func RestartRequired(old, new *Config) []string {
+	startupOnly := func(c Config) Config {
+		c.Filters = nil
+		c.SemanticCache.SimilarityThreshold = 0
+		c.SemanticCache.Scope = ScopeConfig{}
+		c.PromptGuard.CheckPrompt, c.PromptGuard.CheckResponse = false, false
+		c.PromptGuard.StreamCheckInterval = 0
+		c.PromptGuard.Risks = GuardRisksConfig{}
+		c.PromptGuard.OnFailure, c.PromptGuard.FallbackPatterns = GuardFailureConfig{}, nil
+		c.Logging.Level, c.Logging.Redact = "", ""
+		return c
+	}
+	o, n := startupOnly(*old), startupOnly(*new)
+
+	var changed []string
+	if o.Server != n.Server {
+		changed = append(changed, "server")
+	}
+	if !reflect.DeepEqual(o.SemanticCache, n.SemanticCache) {
+		changed = append(changed, "semantic_cache")
+	}
+	if o.Embedding != n.Embedding {
+		changed = append(changed, "embedding")
+	}
+	if !reflect.DeepEqual(o.PromptGuard, n.PromptGuard) {
+		changed = append(changed, "prompt_guard")
+	}
+	if o.Logging != n.Logging {
+		changed = append(changed, "logging")
+	}
+	return changed
+}
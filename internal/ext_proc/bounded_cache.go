@@ -0,0 +1,294 @@
+package ext_proc
+
+import (
+	"container/heap"
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EvictionPolicy selects which entry is dropped when a bounded cache is full
+type EvictionPolicy string
+
+const (
+	// EvictionLRU drops the least recently used entry
+	EvictionLRU EvictionPolicy = "lru"
+	// EvictionLFU drops the least frequently used entry, ties go to the least recently used
+	EvictionLFU EvictionPolicy = "lfu"
+	// EvictionFIFO drops the oldest entry regardless of use
+	EvictionFIFO EvictionPolicy = "fifo"
+)
+
+func ParseEvictionPolicy(s string) (EvictionPolicy, error) {
+	switch p := EvictionPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return EvictionLRU, nil
+	case EvictionLRU, EvictionLFU, EvictionFIFO:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown eviction policy %q", s)
+	}
+}
+
+// CacheLimits bounds a cache, zero values mean unbounded
+type CacheLimits struct {
+	TTL        time.Duration
+	MaxEntries int
+	MaxBytes   int64
+	Policy     EvictionPolicy
+}
+
+type boundedItem[K comparable, V any] struct {
+	key       K
+	value     V
+	size      int64
+	expires   time.Time
+	hits      uint64
+	lastUse   uint64
+	elem      *list.Element
+	heapIndex int
+}
+
+// boundedCache is a map with per-entry expiry and entry/byte budgets enforced by an eviction policy.
+// onEvict is called outside the lock for every entry removed by expiry or eviction, not for explicit deletes.
+type boundedCache[K comparable, V any] struct {
+	mu      sync.Mutex
+	limits  CacheLimits
+	items   map[K]*boundedItem[K, V]
+	order   *list.List
+	freq    lfuHeap[K, V]
+	bytes   int64
+	clock   uint64
+	sizeOf  func(K, V) int64
+	onEvict func(K, V)
+	now     func() time.Time
+}
+
+func newBoundedCache[K comparable, V any](limits CacheLimits, sizeOf func(K, V) int64, onEvict func(K, V)) *boundedCache[K, V] {
+	if limits.Policy == "" {
+		limits.Policy = EvictionLRU
+	}
+	return &boundedCache[K, V]{
+		limits:  limits,
+		items:   make(map[K]*boundedItem[K, V]),
+		order:   list.New(),
+		sizeOf:  sizeOf,
+		onEvict: onEvict,
+		now:     time.Now,
+	}
+}
+
+// Get returns the value and records the access, expired entries are dropped and reported as missing
+func (c *boundedCache[K, V]) Get(key K) (V, bool) {
+	var zero V
+	c.mu.Lock()
+	it, ok := c.items[key]
+	if !ok {
+		c.mu.Unlock()
+		return zero, false
+	}
+	if c.expired(it) {
+		c.removeLocked(it)
+		c.mu.Unlock()
+		c.evicted([]*boundedItem[K, V]{it})
+		return zero, false
+	}
+	c.touchLocked(it)
+	c.mu.Unlock()
+	return it.value, true
+}
+
+// Set stores the value, ttl <= 0 falls back to the configured TTL
+func (c *boundedCache[K, V]) Set(key K, value V, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = c.limits.TTL
+	}
+	var expires time.Time
+	if ttl > 0 {
+		expires = c.now().Add(ttl)
+	}
+	c.SetExpiry(key, value, expires)
+}
+
+// SetExpiry stores the value with an absolute expiry, the zero time never expires
+func (c *boundedCache[K, V]) SetExpiry(key K, value V, expires time.Time) {
+	var size int64
+	if c.sizeOf != nil {
+		size = c.sizeOf(key, value)
+	}
+
+	c.mu.Lock()
+	if old, ok := c.items[key]; ok {
+		c.removeLocked(old)
+	}
+	it := &boundedItem[K, V]{key: key, value: value, size: size, expires: expires}
+	c.items[key] = it
+	c.bytes += size
+	if c.limits.Policy == EvictionLFU {
+		heap.Push(&c.freq, it)
+	} else {
+		it.elem = c.order.PushBack(it)
+	}
+	c.touchLocked(it)
+	evicted := c.enforceLocked(it)
+	c.mu.Unlock()
+	c.evicted(evicted)
+}
+
+// Delete removes the entry without calling onEvict
+func (c *boundedCache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if it, ok := c.items[key]; ok {
+		c.removeLocked(it)
+	}
+}
+
+// Sweep drops every expired entry and returns how many were removed
+func (c *boundedCache[K, V]) Sweep() int {
+	c.mu.Lock()
+	var expired []*boundedItem[K, V]
+	for _, it := range c.items {
+		if c.expired(it) {
+			expired = append(expired, it)
+		}
+	}
+	for _, it := range expired {
+		c.removeLocked(it)
+	}
+	c.mu.Unlock()
+	c.evicted(expired)
+	return len(expired)
+}
+
+// Range calls fn for every live entry until it returns false, fn must not call back into the cache
+func (c *boundedCache[K, V]) Range(fn func(key K, value V, expires time.Time) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, it := range c.items {
+		if c.expired(it) {
+			continue
+		}
+		if !fn(k, it.value, it.expires) {
+			return
+		}
+	}
+}
+
+func (c *boundedCache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.items)
+}
+
+func (c *boundedCache[K, V]) Bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+func (c *boundedCache[K, V]) expired(it *boundedItem[K, V]) bool {
+	return !it.expires.IsZero() && !c.now().Before(it.expires)
+}
+
+func (c *boundedCache[K, V]) touchLocked(it *boundedItem[K, V]) {
+	c.clock++
+	it.hits++
+	it.lastUse = c.clock
+	switch c.limits.Policy {
+	case EvictionLRU:
+		c.order.MoveToBack(it.elem)
+	case EvictionLFU:
+		heap.Fix(&c.freq, it.heapIndex)
+	}
+}
+
+func (c *boundedCache[K, V]) removeLocked(it *boundedItem[K, V]) {
+	delete(c.items, it.key)
+	c.bytes -= it.size
+	if c.limits.Policy == EvictionLFU {
+		heap.Remove(&c.freq, it.heapIndex)
+	} else {
+		c.order.Remove(it.elem)
+	}
+}
+
+// enforceLocked evicts entries until the budgets are met, the just inserted entry is evicted last
+func (c *boundedCache[K, V]) enforceLocked(inserted *boundedItem[K, V]) []*boundedItem[K, V] {
+	var evicted []*boundedItem[K, V]
+	over := func() bool {
+		return (c.limits.MaxEntries > 0 && len(c.items) > c.limits.MaxEntries) ||
+			(c.limits.MaxBytes > 0 && c.bytes > c.limits.MaxBytes)
+	}
+	for over() {
+		victim := c.victimLocked(inserted)
+		if victim == nil {
+			break
+		}
+		c.removeLocked(victim)
+		evicted = append(evicted, victim)
+	}
+	return evicted
+}
+
+func (c *boundedCache[K, V]) victimLocked(inserted *boundedItem[K, V]) *boundedItem[K, V] {
+	if len(c.items) == 0 {
+		return nil
+	}
+	if c.limits.Policy == EvictionLFU {
+		victim := c.freq[0]
+		if victim == inserted && len(c.freq) > 1 {
+			// the new entry always has the lowest count, prefer the next candidate
+			victim = c.freq[1]
+			if len(c.freq) > 2 && c.freq.Less(2, 1) {
+				victim = c.freq[2]
+			}
+		}
+		return victim
+	}
+	front := c.order.Front()
+	victim := front.Value.(*boundedItem[K, V])
+	if victim == inserted && front.Next() != nil {
+		victim = front.Next().Value.(*boundedItem[K, V])
+	}
+	return victim
+}
+
+func (c *boundedCache[K, V]) evicted(items []*boundedItem[K, V]) {
+	if c.onEvict == nil {
+		return
+	}
+	for _, it := range items {
+		c.onEvict(it.key, it.value)
+	}
+}
+
+type lfuHeap[K comparable, V any] []*boundedItem[K, V]
+
+func (h lfuHeap[K, V]) Len() int { return len(h) }
+func (h lfuHeap[K, V]) Less(i, j int) bool {
+	if h[i].hits != h[j].hits {
+		return h[i].hits < h[j].hits
+	}
+	return h[i].lastUse < h[j].lastUse
+}
+func (h lfuHeap[K, V]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+func (h *lfuHeap[K, V]) Push(x any) {
+	it := x.(*boundedItem[K, V])
+	it.heapIndex = len(*h)
+	*h = append(*h, it)
+}
+func (h *lfuHeap[K, V]) Pop() any {
+	old := *h
+	it := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	it.heapIndex = -1
+	return it
+}
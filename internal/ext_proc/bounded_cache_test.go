@@ -0,0 +1,107 @@
+package ext_proc
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("boundedCache", func() {
+	var (
+		now     time.Time
+		evicted []string
+	)
+
+	newCache := func(limits CacheLimits) *boundedCache[string, int] {
+		c := newBoundedCache(limits,
+			func(k string, _ int) int64 { return int64(len(k)) },
+			func(k string, _ int) { evicted = append(evicted, k) })
+		c.now = func() time.Time { return now }
+		return c
+	}
+
+	BeforeEach(func() {
+		now = time.Unix(1700000000, 0)
+		evicted = nil
+	})
+
+	It("should expire entries after their TTL", func() {
+		c := newCache(CacheLimits{TTL: time.Minute})
+		c.Set("a", 1, 0)
+		c.Set("b", 2, 2*time.Minute)
+
+		now = now.Add(90 * time.Second)
+		_, ok := c.Get("a")
+		Expect(ok).To(BeFalse())
+		v, ok := c.Get("b")
+		Expect(ok).To(BeTrue())
+		Expect(v).To(Equal(2))
+		Expect(evicted).To(Equal([]string{"a"}))
+	})
+
+	It("should sweep expired entries", func() {
+		c := newCache(CacheLimits{TTL: time.Minute})
+		c.Set("a", 1, 0)
+		c.Set("b", 2, 0)
+		c.Set("c", 3, time.Hour)
+
+		now = now.Add(2 * time.Minute)
+		Expect(c.Sweep()).To(Equal(2))
+		Expect(c.Len()).To(Equal(1))
+		Expect(evicted).To(ConsistOf("a", "b"))
+	})
+
+	It("should evict the least recently used entry", func() {
+		c := newCache(CacheLimits{MaxEntries: 2, Policy: EvictionLRU})
+		c.Set("a", 1, 0)
+		c.Set("b", 2, 0)
+		c.Get("a")
+		c.Set("c", 3, 0)
+
+		Expect(evicted).To(Equal([]string{"b"}))
+	})
+
+	It("should evict the oldest entry with FIFO regardless of use", func() {
+		c := newCache(CacheLimits{MaxEntries: 2, Policy: EvictionFIFO})
+		c.Set("a", 1, 0)
+		c.Set("b", 2, 0)
+		c.Get("a")
+		c.Set("c", 3, 0)
+
+		Expect(evicted).To(Equal([]string{"a"}))
+	})
+
+	It("should evict the least frequently used entry", func() {
+		c := newCache(CacheLimits{MaxEntries: 2, Policy: EvictionLFU})
+		c.Set("a", 1, 0)
+		c.Set("b", 2, 0)
+		c.Get("a")
+		c.Get("a")
+		c.Get("b")
+		c.Set("c", 3, 0)
+
+		Expect(evicted).To(Equal([]string{"b"}))
+		_, ok := c.Get("c")
+		Expect(ok).To(BeTrue())
+	})
+
+	It("should enforce the byte budget", func() {
+		c := newCache(CacheLimits{MaxBytes: 10})
+		c.Set("aaaa", 1, 0)
+		c.Set("bbbb", 2, 0)
+		c.Set("cccc", 3, 0)
+
+		Expect(evicted).To(Equal([]string{"aaaa"}))
+		Expect(c.Bytes()).To(Equal(int64(8)))
+	})
+
+	It("should not report explicit deletes as evictions", func() {
+		c := newCache(CacheLimits{})
+		c.Set("a", 1, 0)
+		c.Delete("a")
+
+		Expect(c.Len()).To(BeZero())
+		Expect(evicted).To(BeEmpty())
+	})
+})
@@ -0,0 +1,97 @@
+package ext_proc
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cacheThresholdHeader = "x-inferno-cache-threshold"
+	cacheTTLHeader       = "x-inferno-cache-ttl"
+)
+
+// cacheControl holds the cache directives a client sent with a request
+type cacheControl struct {
+	// noCache skips the lookup so a fresh response is generated, the response is still stored
+	noCache bool
+	// noStore keeps the response out of the cache
+	noStore bool
+	// maxAge rejects cached responses older than this, zero means any age
+	maxAge time.Duration
+	// threshold raises the similarity threshold when hasThreshold is set
+	threshold    float64
+	hasThreshold bool
+	// ttl overrides the cache expiry for the stored response, zero keeps the default
+	ttl time.Duration
+}
+
+// parseCacheControl reads Cache-Control, x-inferno-cache-threshold and x-inferno-cache-ttl,
+// invalid values are logged and ignored
+func parseCacheControl(ctx context.Context, headers map[string]string) cacheControl {
+	var cc cacheControl
+	log := logger("cache_control")
+
+	for _, directive := range strings.Split(headers["cache-control"], ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-cache":
+			cc.noCache = true
+		case "no-store":
+			cc.noStore = true
+		case "max-age":
+			secs, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err != nil || secs < 0 {
+				log.WarnContext(ctx, "Ignoring invalid max-age", "value", value)
+				continue
+			}
+			if secs == 0 {
+				cc.noCache = true
+			}
+			cc.maxAge = time.Duration(secs) * time.Second
+		}
+	}
+
+	if v := headers[cacheThresholdHeader]; v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t < 0 || t > 1 {
+			log.WarnContext(ctx, "Ignoring invalid header", "header", cacheThresholdHeader, "value", v)
+		} else {
+			cc.threshold, cc.hasThreshold = t, true
+		}
+	}
+
+	if v := headers[cacheTTLHeader]; v != "" {
+		if ttl, ok := parseTTL(v); ok {
+			cc.ttl = ttl
+		} else {
+			log.WarnContext(ctx, "Ignoring invalid header", "header", cacheTTLHeader, "value", v)
+		}
+	}
+
+	return cc
+}
+
+// parseTTL accepts whole seconds or a Go duration such as 10m
+func parseTTL(v string) (time.Duration, bool) {
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, secs > 0
+	}
+	d, err := time.ParseDuration(v)
+	return d, err == nil && d > 0
+}
+
+// similarityThreshold returns the client override or def, whichever is stricter. Lowering the threshold
+// would let any client be served the nearest entry of its scope, however unrelated.
+func (cc cacheControl) similarityThreshold(def float64) float64 {
+	if cc.hasThreshold {
+		return max(cc.threshold, def)
+	}
+	return def
+}
+
+// acceptsAge reports whether an entry created at created may be served
+func (cc cacheControl) acceptsAge(created time.Time) bool {
+	return cc.maxAge == 0 || time.Since(created) <= cc.maxAge
+}
@@ -0,0 +1,63 @@
+package ext_proc
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("parseCacheControl", func() {
+	It("should parse cache directives", func() {
+		cc := parseCacheControl(context.Background(), map[string]string{"cache-control": "No-Cache, no-store, max-age=60"})
+		Expect(cc.noCache).To(BeTrue())
+		Expect(cc.noStore).To(BeTrue())
+		Expect(cc.maxAge).To(Equal(time.Minute))
+	})
+
+	It("should treat max-age=0 as no-cache", func() {
+		Expect(parseCacheControl(context.Background(), map[string]string{"cache-control": "max-age=0"}).noCache).To(BeTrue())
+	})
+
+	It("should parse the threshold and ttl overrides", func() {
+		cc := parseCacheControl(context.Background(), map[string]string{
+			"x-inferno-cache-threshold": "0.95",
+			"x-inferno-cache-ttl":       "10m",
+		})
+		Expect(cc.similarityThreshold(0.75)).To(Equal(0.95))
+		Expect(cc.similarityThreshold(0.98)).To(Equal(0.98))
+		Expect(cc.ttl).To(Equal(10 * time.Minute))
+
+		Expect(parseCacheControl(context.Background(), map[string]string{"x-inferno-cache-ttl": "30"}).ttl).To(Equal(30 * time.Second))
+	})
+
+	It("should ignore invalid values", func() {
+		cc := parseCacheControl(context.Background(), map[string]string{
+			"cache-control":             "max-age=soon",
+			"x-inferno-cache-threshold": "1.5",
+			"x-inferno-cache-ttl":       "-5",
+		})
+		Expect(cc.maxAge).To(BeZero())
+		Expect(cc.similarityThreshold(0.75)).To(Equal(0.75))
+		Expect(cc.ttl).To(BeZero())
+	})
+
+	It("should only let the ttl override shorten the expiry", func() {
+		rc := &RequestContext{control: cacheControl{ttl: time.Hour}}
+		Expect(rc.cacheTTL(24 * time.Hour)).To(Equal(time.Hour))
+		Expect(rc.cacheTTL(10 * time.Minute)).To(BeZero())
+		Expect(rc.cacheTTL(0)).To(Equal(time.Hour))
+
+		rc.route.CacheTTL = 30 * time.Minute
+		Expect(rc.cacheTTL(24 * time.Hour)).To(Equal(30 * time.Minute))
+		rc.control.ttl = time.Minute
+		Expect(rc.cacheTTL(24 * time.Hour)).To(Equal(time.Minute))
+	})
+
+	It("should reject entries older than max-age", func() {
+		cc := parseCacheControl(context.Background(), map[string]string{"cache-control": "max-age=60"})
+		Expect(cc.acceptsAge(time.Now().Add(-30 * time.Second))).To(BeTrue())
+		Expect(cc.acceptsAge(time.Now().Add(-2 * time.Minute))).To(BeFalse())
+	})
+})
@@ -0,0 +1,222 @@
+package ext_proc
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// wantsStream reports whether the request asked for a streamed response, and whether the stream should end
+// with a usage chunk as requested by stream_options.include_usage
+func wantsStream(bodyMap map[string]interface{}) (stream, includeUsage bool) {
+	stream, _ = bodyMap["stream"].(bool)
+	if opts, ok := bodyMap["stream_options"].(map[string]interface{}); ok {
+		includeUsage, _ = opts["include_usage"].(bool)
+	}
+	return stream, includeUsage
+}
+
+// cachedCompletion is the union of the chat.completion, text_completion and Responses API shapes we cache
+type cachedCompletion struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Created   int64  `json:"created"`
+	CreatedAt int64  `json:"created_at"`
+	Model     string `json:"model"`
+	Choices   []struct {
+		Index   int    `json:"index"`
+		Text    string `json:"text"`
+		Message *struct {
+			Role      string `json:"role"`
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				ID       string `json:"id"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"message"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Output []struct {
+		Type string `json:"type"`
+		// CallID, Name and Arguments are set on function_call items
+		CallID    string `json:"call_id"`
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+		Content   []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	} `json:"output"`
+	Usage json.RawMessage `json:"usage"`
+}
+
+// renderEventStream re-renders a cached completion as the event stream the model server would have sent,
+// it returns false when the cached body is not a completion we know how to stream
+func renderEventStream(cached []byte, includeUsage bool) ([]byte, bool) {
+	var c cachedCompletion
+	if err := json.Unmarshal(cached, &c); err != nil {
+		return nil, false
+	}
+	var out bytes.Buffer
+	switch {
+	case c.Object == "response" || c.Output != nil:
+		renderResponsesStream(&out, c, cached)
+	case len(c.Choices) > 0 && c.Choices[0].Message != nil:
+		renderChatStream(&out, c, includeUsage)
+	case len(c.Choices) > 0:
+		renderCompletionStream(&out, c, includeUsage)
+	default:
+		return nil, false
+	}
+	return out.Bytes(), true
+}
+
+func writeEvent(out *bytes.Buffer, event string, data interface{}) {
+	if event != "" {
+		out.WriteString("event: " + event + "\n")
+	}
+	b, _ := json.Marshal(data)
+	out.WriteString("data: ")
+	out.Write(b)
+	out.WriteString("\n\n")
+}
+
+// renderChatStream follows the chat.completion.chunk sequence: the role, the content, the tool calls, the finish
+// reason and, when requested, a final chunk with the usage and no choices
+func renderChatStream(out *bytes.Buffer, c cachedCompletion, includeUsage bool) {
+	chunk := func(choices []interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"id":      c.ID,
+			"object":  "chat.completion.chunk",
+			"created": c.Created,
+			"model":   c.Model,
+			"choices": choices,
+		}
+	}
+	for _, ch := range c.Choices {
+		if ch.Message == nil {
+			continue
+		}
+		role := ch.Message.Role
+		if role == "" {
+			role = "assistant"
+		}
+		writeEvent(out, "", chunk([]interface{}{map[string]interface{}{
+			"index": ch.Index, "delta": map[string]interface{}{"role": role, "content": ""}, "finish_reason": nil,
+		}}))
+		if ch.Message.Content != "" || len(ch.Message.ToolCalls) == 0 {
+			writeEvent(out, "", chunk([]interface{}{map[string]interface{}{
+				"index": ch.Index, "delta": map[string]interface{}{"content": ch.Message.Content}, "finish_reason": nil,
+			}}))
+		}
+		for k, tc := range ch.Message.ToolCalls {
+			call := map[string]interface{}{
+				"index": k, "id": tc.ID, "type": "function",
+				"function": map[string]interface{}{"name": tc.Function.Name, "arguments": tc.Function.Arguments},
+			}
+			writeEvent(out, "", chunk([]interface{}{map[string]interface{}{
+				"index": ch.Index, "delta": map[string]interface{}{"tool_calls": []interface{}{call}}, "finish_reason": nil,
+			}}))
+		}
+		writeEvent(out, "", chunk([]interface{}{map[string]interface{}{
+			"index": ch.Index, "delta": map[string]interface{}{}, "finish_reason": finishReason(ch.FinishReason),
+		}}))
+	}
+	if includeUsage && len(c.Usage) > 0 {
+		last := chunk([]interface{}{})
+		last["usage"] = c.Usage
+		writeEvent(out, "", last)
+	}
+	out.WriteString("data: [DONE]\n\n")
+}
+
+func renderCompletionStream(out *bytes.Buffer, c cachedCompletion, includeUsage bool) {
+	chunk := func(choices []interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"id":      c.ID,
+			"object":  "text_completion",
+			"created": c.Created,
+			"model":   c.Model,
+			"choices": choices,
+		}
+	}
+	for _, ch := range c.Choices {
+		writeEvent(out, "", chunk([]interface{}{map[string]interface{}{
+			"index": ch.Index, "text": ch.Text, "finish_reason": finishReason(ch.FinishReason),
+		}}))
+	}
+	if includeUsage && len(c.Usage) > 0 {
+		last := chunk([]interface{}{})
+		last["usage"] = c.Usage
+		writeEvent(out, "", last)
+	}
+	out.WriteString("data: [DONE]\n\n")
+}
+
+// renderResponsesStream emits the Responses API lifecycle events for each output message and function call,
+// the final response.completed event carries the cached response unchanged
+func renderResponsesStream(out *bytes.Buffer, c cachedCompletion, cached []byte) {
+	seq := 0
+	event := func(typ string, fields map[string]interface{}) {
+		fields["type"] = typ
+		fields["sequence_number"] = seq
+		seq++
+		writeEvent(out, typ, fields)
+	}
+	inProgress := map[string]interface{}{
+		"id": c.ID, "object": "response", "created_at": c.CreatedAt, "model": c.Model, "status": "in_progress", "output": []interface{}{},
+	}
+	event("response.created", map[string]interface{}{"response": inProgress})
+
+	for i, item := range c.Output {
+		if item.Type == "function_call" {
+			call := map[string]interface{}{"type": "function_call", "call_id": item.CallID, "name": item.Name, "arguments": "", "status": "in_progress"}
+			event("response.output_item.added", map[string]interface{}{"output_index": i, "item": call})
+			event("response.function_call_arguments.delta", map[string]interface{}{"output_index": i, "delta": item.Arguments})
+			event("response.function_call_arguments.done", map[string]interface{}{"output_index": i, "arguments": item.Arguments})
+			event("response.output_item.done", map[string]interface{}{
+				"output_index": i, "item": with(with(call, "arguments", item.Arguments), "status", "completed"),
+			})
+			continue
+		}
+		if item.Type != "message" {
+			continue
+		}
+		event("response.output_item.added", map[string]interface{}{
+			"output_index": i,
+			"item":         map[string]interface{}{"type": "message", "role": "assistant", "status": "in_progress", "content": []interface{}{}},
+		})
+		for j, part := range item.Content {
+			if part.Type != "output_text" {
+				continue
+			}
+			pos := map[string]interface{}{"output_index": i, "content_index": j}
+			event("response.content_part.added", with(pos, "part", map[string]interface{}{"type": "output_text", "text": ""}))
+			event("response.output_text.delta", with(pos, "delta", part.Text))
+			event("response.output_text.done", with(pos, "text", part.Text))
+			event("response.content_part.done", with(pos, "part", map[string]interface{}{"type": "output_text", "text": part.Text}))
+		}
+		event("response.output_item.done", map[string]interface{}{"output_index": i})
+	}
+
+	event("response.completed", map[string]interface{}{"response": json.RawMessage(cached)})
+}
+
+// with copies fields and adds key
+func with(fields map[string]interface{}, key string, value interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields)+1)
+	for k, v := range fields {
+		out[k] = v
+	}
+	out[key] = value
+	return out
+}
+
+func finishReason(r *string) string {
+	if r == nil || *r == "" {
+		return "stop"
+	}
+	return *r
+}
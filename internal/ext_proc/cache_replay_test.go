@@ -0,0 +1,116 @@
+package ext_proc
+
+import (
+	"encoding/json"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("renderEventStream", func() {
+	const chatCompletion = `{"id":"chatcmpl-1","object":"chat.completion","created":1700000000,"model":"gpt-4.1",` +
+		`"choices":[{"index":0,"message":{"role":"assistant","content":"A container orchestrator."},"finish_reason":"stop"}],` +
+		`"usage":{"prompt_tokens":5,"completion_tokens":4,"total_tokens":9}}`
+
+	replay := func(cached string, includeUsage bool) (*sseAccumulator, string) {
+		rendered, ok := renderEventStream([]byte(cached), includeUsage)
+		Expect(ok).To(BeTrue())
+		a := &sseAccumulator{}
+		a.Write(rendered)
+		a.Close()
+		return a, string(rendered)
+	}
+
+	It("should replay a chat completion as chunks", func() {
+		a, rendered := replay(chatCompletion, false)
+		Expect(a.Text()).To(Equal("A container orchestrator."))
+		Expect(a.object).To(Equal("chat.completion.chunk"))
+		Expect(a.model).To(Equal("gpt-4.1"))
+		Expect(a.choices[0].finishReason).To(Equal("stop"))
+		Expect(a.done).To(BeTrue())
+		Expect(a.usage).To(BeNil())
+		Expect(rendered).To(HaveSuffix("data: [DONE]\n\n"))
+	})
+
+	It("should end with a usage chunk when requested", func() {
+		a, _ := replay(chatCompletion, true)
+		Expect(a.usage).To(Equal(&tokenUsage{PromptTokens: 5, CompletionTokens: 4, TotalTokens: 9}))
+	})
+
+	It("should round trip through the stream accumulator", func() {
+		a, _ := replay(chatCompletion, true)
+		var got, want map[string]interface{}
+		Expect(json.Unmarshal(a.completion(), &got)).To(Succeed())
+		Expect(json.Unmarshal([]byte(chatCompletion), &want)).To(Succeed())
+		Expect(got).To(Equal(want))
+	})
+
+	It("should keep every choice", func() {
+		_, rendered := replay(`{"object":"chat.completion","choices":[`+
+			`{"index":0,"message":{"role":"assistant","content":"a"}},{"index":1,"message":{"role":"assistant","content":"b"}}]}`, false)
+		Expect(rendered).To(ContainSubstring(`"index":1`))
+		Expect(rendered).To(ContainSubstring(`"content":"b"`))
+	})
+
+	It("should replay a text completion", func() {
+		a, _ := replay(`{"id":"cmpl-1","object":"text_completion","choices":[{"index":0,"text":"Once upon a time","finish_reason":"length"}]}`, false)
+		Expect(a.Text()).To(Equal("Once upon a time"))
+		Expect(a.object).To(Equal("text_completion"))
+		Expect(a.choices[0].finishReason).To(Equal("length"))
+	})
+
+	It("should replay a Responses API response as lifecycle events", func() {
+		cached := `{"id":"resp_1","object":"response","model":"gpt-4.1","status":"completed",` +
+			`"output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"Once upon a time"}]}],` +
+			`"usage":{"input_tokens":3,"output_tokens":4,"total_tokens":7}}`
+		a, rendered := replay(cached, false)
+		Expect(a.Text()).To(Equal("Once upon a time"))
+		Expect(a.usage).To(Equal(&tokenUsage{PromptTokens: 3, CompletionTokens: 4, TotalTokens: 7}))
+		Expect(a.done).To(BeTrue())
+
+		var events []string
+		for _, l := range strings.Split(rendered, "\n") {
+			if ev, ok := strings.CutPrefix(l, "event: "); ok {
+				events = append(events, ev)
+			}
+		}
+		Expect(events).To(Equal([]string{
+			"response.created",
+			"response.output_item.added",
+			"response.content_part.added",
+			"response.output_text.delta",
+			"response.output_text.done",
+			"response.content_part.done",
+			"response.output_item.done",
+			"response.completed",
+		}))
+	})
+
+	It("should replay tool calls", func() {
+		calls := []ToolCall{{ID: "call_1", Name: "get_weather", Arguments: `{"city":"Paris"}`}, {ID: "call_2", Name: "get_time", Arguments: "{}"}}
+		a, _ := replay(`{"object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[`+
+			`{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}},`+
+			`{"id":"call_2","type":"function","function":{"name":"get_time","arguments":"{}"}}]},"finish_reason":"tool_calls"}]}`, false)
+		Expect(a.output()).To(Equal(responseOutput{ToolCalls: calls}))
+		Expect(a.choices[0].finishReason).To(Equal("tool_calls"))
+
+		a, _ = replay(`{"object":"response","output":[{"type":"function_call","call_id":"call_2","name":"get_time","arguments":"{}"}]}`, false)
+		Expect(a.output()).To(Equal(responseOutput{ToolCalls: calls[1:]}))
+	})
+
+	It("should refuse bodies it cannot stream", func() {
+		_, ok := renderEventStream([]byte(`{"data":[{"embedding":[1,2]}]}`), false)
+		Expect(ok).To(BeFalse())
+		_, ok = renderEventStream([]byte(`not json`), false)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should detect streaming requests", func() {
+		stream, usage := wantsStream(map[string]interface{}{"stream": true, "stream_options": map[string]interface{}{"include_usage": true}})
+		Expect(stream).To(BeTrue())
+		Expect(usage).To(BeTrue())
+		stream, _ = wantsStream(map[string]interface{}{})
+		Expect(stream).To(BeFalse())
+	})
+})
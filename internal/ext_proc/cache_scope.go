@@ -0,0 +1,60 @@
+package ext_proc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+
+	"github.com/kuadrant/inferno/internal/config"
+)
+
+// ScopeConfig selects the request attributes that partition the semantic cache,
+// a lookup only matches entries stored by requests with identical values
+type ScopeConfig struct {
+	// BodyFields are top-level request body fields, compared by their JSON value
+	BodyFields []string
+	// Headers are request header names, pseudo-headers such as :path included
+	Headers []string
+}
+
+func scopeConfig(c config.ScopeConfig) ScopeConfig {
+	return ScopeConfig{BodyFields: c.Fields, Headers: c.Headers}
+}
+
+// Key derives the partition key for a request. Values are hashed so that credentials
+// such as the Authorization header are never stored or logged in clear text.
+func (c ScopeConfig) Key(body map[string]interface{}, headers map[string]string) string {
+	if len(c.BodyFields) == 0 && len(c.Headers) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	for _, f := range c.BodyFields {
+		// json.Marshal sorts map keys, so nested objects hash the same regardless of field order
+		v, _ := json.Marshal(body[f])
+		h.Write([]byte("body:" + f + "="))
+		h.Write(v)
+		h.Write([]byte{0})
+	}
+	for _, name := range c.Headers {
+		h.Write([]byte("header:" + strings.ToLower(name) + "="))
+		h.Write([]byte(headers[strings.ToLower(name)]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// headerMap flattens Envoy request headers into lower-cased names
+func headerMap(headers []*configPb.HeaderValue) map[string]string {
+	out := make(map[string]string, len(headers))
+	for _, h := range headers {
+		v := h.Value
+		if v == "" && len(h.RawValue) > 0 {
+			v = string(h.RawValue)
+		}
+		out[strings.ToLower(h.Key)] = v
+	}
+	return out
+}
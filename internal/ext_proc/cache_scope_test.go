@@ -0,0 +1,56 @@
+package ext_proc
+
+import (
+	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kuadrant/inferno/internal/config"
+)
+
+var _ = Describe("ScopeConfig", func() {
+	cfg := scopeConfig(config.Default().SemanticCache.Scope)
+	headers := map[string]string{":path": "/v1/chat/completions", "authorization": "Bearer key-a"}
+
+	body := func(model string) map[string]interface{} {
+		return map[string]interface{}{
+			"model":           model,
+			"temperature":     0.2,
+			"response_format": map[string]interface{}{"type": "json_object", "strict": true},
+			"messages":        []interface{}{"ignored"},
+		}
+	}
+
+	It("should give identical requests the same scope", func() {
+		Expect(cfg.Key(body("gpt-4.1"), headers)).To(Equal(cfg.Key(body("gpt-4.1"), headers)))
+	})
+
+	It("should separate models", func() {
+		Expect(cfg.Key(body("gpt-4.1"), headers)).NotTo(Equal(cfg.Key(body("gpt-3.5-turbo-instruct"), headers)))
+	})
+
+	It("should separate API keys without exposing them", func() {
+		other := map[string]string{":path": "/v1/chat/completions", "authorization": "Bearer key-b"}
+		key := cfg.Key(body("gpt-4.1"), headers)
+		Expect(key).NotTo(Equal(cfg.Key(body("gpt-4.1"), other)))
+		Expect(key).NotTo(ContainSubstring("key-a"))
+	})
+
+	It("should ignore fields outside the scope", func() {
+		b := body("gpt-4.1")
+		b["messages"] = []interface{}{"different"}
+		Expect(cfg.Key(b, headers)).To(Equal(cfg.Key(body("gpt-4.1"), headers)))
+	})
+
+	It("should be empty when nothing is scoped", func() {
+		Expect(ScopeConfig{}.Key(body("gpt-4.1"), headers)).To(BeEmpty())
+	})
+
+	It("should read raw header values", func() {
+		m := headerMap([]*configPb.HeaderValue{
+			{Key: "X-Tenant", RawValue: []byte("acme")},
+			{Key: ":path", Value: "/v1/completions"},
+		})
+		Expect(m).To(Equal(map[string]string{"x-tenant": "acme", ":path": "/v1/completions"}))
+	})
+})
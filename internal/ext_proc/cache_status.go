@@ -0,0 +1,77 @@
+package ext_proc
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/kuadrant/inferno/internal/metrics"
+)
+
+const (
+	cacheStatusHeader     = "x-inferno-cache"
+	cacheSimilarityHeader = "x-inferno-cache-similarity"
+	cacheAgeHeader        = "x-inferno-cache-age"
+	cacheEntryHeader      = "x-inferno-cache-entry"
+)
+
+type cacheStatus string
+
+const (
+	cacheHit    cacheStatus = "HIT"
+	cacheMiss   cacheStatus = "MISS"
+	cacheBypass cacheStatus = "BYPASS"
+)
+
+// cacheResult records the outcome of the semantic cache lookup for a request
+type cacheResult struct {
+	status cacheStatus
+	// similarity of the best candidate, reported on misses too so thresholds can be tuned
+	similarity   float64
+	hasCandidate bool
+	// entry is the served entry on a hit
+	entry *CacheEntry
+}
+
+// headers returns the diagnostic response headers, or nil when no lookup was attempted
+func (cr cacheResult) headers() []*configPb.HeaderValueOption {
+	if cr.status == "" {
+		return nil
+	}
+	headers := []*configPb.HeaderValueOption{cacheHeader(cacheStatusHeader, string(cr.status))}
+	if cr.hasCandidate {
+		headers = append(headers, cacheHeader(cacheSimilarityHeader, strconv.FormatFloat(cr.similarity, 'f', 4, 64)))
+	}
+	if cr.entry != nil {
+		age := int64(time.Since(cr.entry.CreateTime).Seconds())
+		headers = append(headers,
+			cacheHeader(cacheAgeHeader, strconv.FormatInt(max(age, 0), 10)),
+			cacheHeader(cacheEntryHeader, cr.entry.ID),
+		)
+	}
+	return headers
+}
+
+// record counts the lookup in the cache metrics
+func (cr cacheResult) record(route string) {
+	if cr.status == "" {
+		return
+	}
+	metrics.CacheLookups.WithLabelValues(route, strings.ToLower(string(cr.status))).Inc()
+	if cr.hasCandidate {
+		metrics.CacheSimilarity.Observe(cr.similarity)
+	}
+}
+
+func cacheHeader(key, value string) *configPb.HeaderValueOption {
+	return &configPb.HeaderValueOption{
+		Header: &configPb.HeaderValue{
+			Key:   key,
+			Value: value,
+		},
+		Append: wrapperspb.Bool(false),
+	}
+}
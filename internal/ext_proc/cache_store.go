@@ -0,0 +1,144 @@
+package ext_proc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// CacheStore holds semantic cache entries and answers similarity lookups against them
+type CacheStore interface {
+	// Lookup returns the live entry in scope most similar to vec and its cosine similarity, or nil if there is none
+	Lookup(ctx context.Context, scope string, vec []float64) (*CacheEntry, float64, error)
+	// Store adds the entry, ttl <= 0 uses the store's default expiry
+	Store(ctx context.Context, e *CacheEntry, ttl time.Duration) error
+	// Ping checks the store can be reached
+	Ping(ctx context.Context) error
+	// Close releases connections held by the store
+	Close() error
+}
+
+func newEntryID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// MemoryCacheStore keeps entries in process, bounded by CacheLimits across all scopes,
+// with one VectorIndex per scope so lookups never cross partitions
+type MemoryCacheStore struct {
+	newIndex func() VectorIndex
+	mu       sync.RWMutex
+	indexes  map[string]VectorIndex
+	entries  *boundedCache[*CacheEntry, struct{}]
+}
+
+func NewMemoryCacheStore(newIndex func() VectorIndex, limits CacheLimits) *MemoryCacheStore {
+	s := &MemoryCacheStore{
+		newIndex: newIndex,
+		indexes:  make(map[string]VectorIndex),
+	}
+	s.entries = newBoundedCache(limits,
+		func(e *CacheEntry, _ struct{}) int64 { return e.size() },
+		func(e *CacheEntry, _ struct{}) { s.removeFromIndex(e) })
+	return s
+}
+
+// Lookup drops the nearest entry if it has expired and reports a miss
+func (s *MemoryCacheStore) Lookup(_ context.Context, scope string, vec []float64) (*CacheEntry, float64, error) {
+	s.mu.RLock()
+	index, ok := s.indexes[scope]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, 0, nil
+	}
+	e, sim := index.Search(vec)
+	if e == nil {
+		return nil, 0, nil
+	}
+	if _, ok := s.entries.Get(e); !ok {
+		return nil, 0, nil
+	}
+	return e, sim, nil
+}
+
+func (s *MemoryCacheStore) Store(_ context.Context, e *CacheEntry, ttl time.Duration) error {
+	if err := s.addToIndex(e); err != nil {
+		return err
+	}
+	s.entries.Set(e, struct{}{}, ttl)
+	return nil
+}
+
+// addToIndex holds the lock across the Add, or removeFromIndex could drop the scope's index as empty
+// before the entry lands in it
+func (s *MemoryCacheStore) addToIndex(e *CacheEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index, ok := s.indexes[e.Scope]
+	if !ok {
+		index = s.newIndex()
+		s.indexes[e.Scope] = index
+	}
+	return index.Add(e)
+}
+
+func (s *MemoryCacheStore) removeFromIndex(e *CacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	index, ok := s.indexes[e.Scope]
+	if !ok {
+		return
+	}
+	index.Remove(e)
+	if index.Len() == 0 {
+		delete(s.indexes, e.Scope)
+	}
+}
+
+// Sweep drops expired entries and returns how many were removed
+func (s *MemoryCacheStore) Sweep() int {
+	return s.entries.Sweep()
+}
+
+func (s *MemoryCacheStore) Len() int {
+	return s.entries.Len()
+}
+
+func (s *MemoryCacheStore) Ping(context.Context) error {
+	return nil
+}
+
+func (s *MemoryCacheStore) Close() error {
+	return nil
+}
+
+// snapshotEntries returns every live entry with its expiry
+func (s *MemoryCacheStore) snapshotEntries() []snapshotEntry {
+	var out []snapshotEntry
+	s.entries.Range(func(e *CacheEntry, _ struct{}, expires time.Time) bool {
+		out = append(out, snapshotEntry{Entry: e, Expires: expires})
+		return true
+	})
+	return out
+}
+
+// restoreEntries adds snapshot entries that have not expired yet and returns how many were restored
+func (s *MemoryCacheStore) restoreEntries(entries []snapshotEntry) int {
+	now := time.Now()
+	restored := 0
+	for _, se := range entries {
+		if !se.Expires.IsZero() && !now.Before(se.Expires) {
+			continue
+		}
+		if err := s.addToIndex(se.Entry); err != nil {
+			logger("semantic_cache").Warn("Skipping snapshot entry", "error", err)
+			continue
+		}
+		s.entries.SetExpiry(se.Entry, struct{}{}, se.Expires)
+		restored++
+	}
+	return restored
+}
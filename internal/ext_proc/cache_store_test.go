@@ -0,0 +1,172 @@
+package ext_proc_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kuadrant/inferno/internal/ext_proc"
+)
+
+func newFlatIndex() ext_proc.VectorIndex {
+	return ext_proc.NewFlatIndex()
+}
+
+var _ = Describe("CacheStore", func() {
+	ctx := context.Background()
+
+	Context("MemoryCacheStore", func() {
+		It("should return the nearest stored entry", func() {
+			store := ext_proc.NewMemoryCacheStore(newFlatIndex, ext_proc.CacheLimits{})
+			Expect(store.Store(ctx, &ext_proc.CacheEntry{ID: "a", Embedding: []float64{1, 0}}, 0)).To(Succeed())
+			Expect(store.Store(ctx, &ext_proc.CacheEntry{ID: "b", Embedding: []float64{0, 1}}, 0)).To(Succeed())
+
+			e, sim, err := store.Lookup(ctx, "", []float64{0.1, 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(e.ID).To(Equal("b"))
+			Expect(sim).To(BeNumerically(">", 0.99))
+		})
+
+		It("should drop entries once the entry limit is reached", func() {
+			store := ext_proc.NewMemoryCacheStore(newFlatIndex, ext_proc.CacheLimits{MaxEntries: 1})
+			Expect(store.Store(ctx, &ext_proc.CacheEntry{ID: "a", Embedding: []float64{1, 0}}, 0)).To(Succeed())
+			Expect(store.Store(ctx, &ext_proc.CacheEntry{ID: "b", Embedding: []float64{0, 1}}, 0)).To(Succeed())
+
+			e, _, err := store.Lookup(ctx, "", []float64{1, 1})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(e).NotTo(BeNil())
+			Expect(e.ID).To(Equal("b"))
+			Expect(store.Len()).To(Equal(1))
+		})
+
+		It("should not keep entries its index rejects", func() {
+			store := ext_proc.NewMemoryCacheStore(func() ext_proc.VectorIndex { return ext_proc.NewVectorIndex("hnsw") }, ext_proc.CacheLimits{})
+			Expect(store.Store(ctx, &ext_proc.CacheEntry{ID: "a", Embedding: []float64{1, 0}}, 0)).To(Succeed())
+			Expect(store.Store(ctx, &ext_proc.CacheEntry{ID: "b", Embedding: []float64{1, 0, 0}}, 0)).To(MatchError(ContainSubstring("dimensions")))
+			Expect(store.Len()).To(Equal(1))
+		})
+
+		It("should only match entries in the same scope", func() {
+			store := ext_proc.NewMemoryCacheStore(newFlatIndex, ext_proc.CacheLimits{})
+			Expect(store.Store(ctx, &ext_proc.CacheEntry{ID: "a", Scope: "tenant-a", Embedding: []float64{1, 0}}, 0)).To(Succeed())
+
+			e, _, err := store.Lookup(ctx, "tenant-b", []float64{1, 0})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(e).To(BeNil())
+
+			e, _, err = store.Lookup(ctx, "tenant-a", []float64{1, 0})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(e.ID).To(Equal("a"))
+		})
+	})
+
+	Context("RedisCacheStore", func() {
+		var (
+			mr       *miniredis.Miniredis
+			replicaA *ext_proc.RedisCacheStore
+			replicaB *ext_proc.RedisCacheStore
+		)
+
+		BeforeEach(func() {
+			mr = miniredis.RunT(GinkgoT())
+			var err error
+			replicaA, err = ext_proc.NewRedisCacheStore(ext_proc.RedisCacheStoreConfig{URL: "redis://" + mr.Addr()})
+			Expect(err).NotTo(HaveOccurred())
+			replicaB, err = ext_proc.NewRedisCacheStore(ext_proc.RedisCacheStoreConfig{URL: "redis://" + mr.Addr()})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			replicaA.Close()
+			replicaB.Close()
+		})
+
+		It("should share entries between replicas", func() {
+			created := time.Unix(1700000000, 0)
+			Expect(replicaA.Store(ctx, &ext_proc.CacheEntry{
+				ID:         "abc",
+				Prompt:     "What is Kubernetes?",
+				Embedding:  []float64{0.6, 0.8},
+				Response:   []byte(`{"choices":[]}`),
+				CreateTime: created,
+			}, 0)).To(Succeed())
+
+			e, sim, err := replicaB.Lookup(ctx, "", []float64{0.6, 0.8})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(e).NotTo(BeNil())
+			Expect(e.ID).To(Equal("abc"))
+			Expect(e.Prompt).To(Equal("What is Kubernetes?"))
+			Expect(string(e.Response)).To(Equal(`{"choices":[]}`))
+			Expect(e.CreateTime.Equal(created)).To(BeTrue())
+			Expect(sim).To(BeNumerically("~", 1.0, 1e-6))
+		})
+
+		It("should only match entries in the same scope", func() {
+			Expect(replicaA.Store(ctx, &ext_proc.CacheEntry{ID: "abc", Scope: "tenant-a", Embedding: []float64{1, 0}}, 0)).To(Succeed())
+
+			e, _, err := replicaB.Lookup(ctx, "tenant-b", []float64{1, 0})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(e).To(BeNil())
+
+			e, _, err = replicaB.Lookup(ctx, "tenant-a", []float64{1, 0})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(e.Scope).To(Equal("tenant-a"))
+		})
+
+		It("should expire entries with their TTL", func() {
+			Expect(replicaA.Store(ctx, &ext_proc.CacheEntry{ID: "abc", Embedding: []float64{1, 0}}, time.Minute)).To(Succeed())
+			mr.FastForward(2 * time.Minute)
+
+			e, _, err := replicaB.Lookup(ctx, "", []float64{1, 0})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(e).To(BeNil())
+		})
+
+		It("should report connection failures", func() {
+			mr.Close()
+			_, _, err := replicaA.Lookup(ctx, "", []float64{1, 0})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should refuse an existing index with different dimensions", func() {
+			Expect(mr.Server().Register("FT.CREATE", func(c *server.Peer, _ string, _ []string) {
+				c.WriteError("Index already exists")
+			})).To(Succeed())
+			Expect(mr.Server().Register("FT.INFO", func(c *server.Peer, _ string, _ []string) {
+				c.WriteLen(4)
+				c.WriteBulk("index_name")
+				c.WriteBulk("inferno-semantic-cache")
+				c.WriteBulk("attributes")
+				c.WriteLen(1)
+				c.WriteLen(6)
+				for _, f := range []string{"identifier", "embedding", "type", "VECTOR", "dim"} {
+					c.WriteBulk(f)
+				}
+				c.WriteInt(3)
+			})).To(Succeed())
+
+			err := replicaA.Store(ctx, &ext_proc.CacheEntry{ID: "abc", Embedding: []float64{1, 0}}, 0)
+			Expect(err).To(MatchError(ContainSubstring("holds 3 dimensional vectors but embeddings have 2")))
+		})
+
+		It("should refuse embeddings of another dimension than the index it created", func() {
+			Expect(mr.Server().Register("FT.CREATE", func(c *server.Peer, _ string, _ []string) {
+				c.WriteOK()
+			})).To(Succeed())
+
+			Expect(replicaA.Store(ctx, &ext_proc.CacheEntry{ID: "abc", Embedding: []float64{1, 0}}, 0)).To(Succeed())
+			err := replicaA.Store(ctx, &ext_proc.CacheEntry{ID: "def", Embedding: []float64{1, 0, 0}}, 0)
+			Expect(err).To(MatchError(ContainSubstring("holds 2 dimensional vectors but embeddings have 3")))
+		})
+
+		It("should fail its health check once redis is down", func() {
+			Expect(replicaA.Ping(ctx)).To(Succeed())
+			mr.Close()
+			Expect(replicaA.Ping(ctx)).NotTo(Succeed())
+		})
+	})
+})
@@ -0,0 +1,245 @@
+package ext_proc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Turn is a message of the conversation carried by a request
+type Turn struct {
+	// Role is system, developer, user, assistant or tool
+	Role string
+	// Content is the text of the message, its text parts joined when it has several
+	Content string
+	// ToolCalls are the function calls of an assistant turn
+	ToolCalls []ToolCall
+	// ToolCallID is the call a tool turn answers
+	ToolCallID string
+}
+
+// ToolCall is a function call made by the assistant
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments string
+}
+
+// String renders the call as it is shown to the guard, name(arguments)
+func (tc ToolCall) String() string {
+	return fmt.Sprintf("%s(%s)", tc.Name, tc.Arguments)
+}
+
+// extractConversation returns the turns of a chat, completions or Responses API request
+func extractConversation(bodyMap map[string]interface{}) []Turn {
+	if msgs, ok := bodyMap["messages"].([]interface{}); ok {
+		return chatTurns(msgs)
+	}
+	switch p := bodyMap["prompt"].(type) {
+	case string:
+		return []Turn{{Role: openai.ChatMessageRoleUser, Content: p}}
+	case []interface{}:
+		return []Turn{{Role: openai.ChatMessageRoleUser, Content: contentText(p)}}
+	}
+	if _, ok := bodyMap["input"]; ok {
+		return responsesTurns(bodyMap)
+	}
+	return nil
+}
+
+// `/v1/chat/completions` messages, with the legacy function role and function_call
+func chatTurns(msgs []interface{}) []Turn {
+	var turns []Turn
+	for _, m := range msgs {
+		mm, ok := m.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		t := Turn{Content: contentText(mm["content"])}
+		t.Role, _ = mm["role"].(string)
+		if t.Role == openai.ChatMessageRoleFunction {
+			t.Role = openai.ChatMessageRoleTool
+		}
+		t.ToolCallID, _ = mm["tool_call_id"].(string)
+		if calls, ok := mm["tool_calls"].([]interface{}); ok {
+			for _, c := range calls {
+				cm, _ := c.(map[string]interface{})
+				tc := functionCall(cm["function"])
+				tc.ID, _ = cm["id"].(string)
+				t.ToolCalls = append(t.ToolCalls, tc)
+			}
+		}
+		if fc, ok := mm["function_call"]; ok {
+			t.ToolCalls = append(t.ToolCalls, functionCall(fc))
+		}
+		turns = append(turns, t)
+	}
+	return turns
+}
+
+// `/v1/responses` instructions and input, a string or a list of messages and function call items
+func responsesTurns(bodyMap map[string]interface{}) []Turn {
+	var turns []Turn
+	if s, ok := bodyMap["instructions"].(string); ok && s != "" {
+		turns = append(turns, Turn{Role: openai.ChatMessageRoleSystem, Content: s})
+	}
+	switch input := bodyMap["input"].(type) {
+	case string:
+		turns = append(turns, Turn{Role: openai.ChatMessageRoleUser, Content: input})
+	case []interface{}:
+		for _, item := range input {
+			im, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch im["type"] {
+			case "function_call":
+				tc := functionCall(im)
+				tc.ID, _ = im["call_id"].(string)
+				turns = append(turns, Turn{Role: openai.ChatMessageRoleAssistant, ToolCalls: []ToolCall{tc}})
+			case "function_call_output":
+				t := Turn{Role: openai.ChatMessageRoleTool, Content: contentText(im["output"])}
+				t.ToolCallID, _ = im["call_id"].(string)
+				turns = append(turns, t)
+			case nil, "message":
+				t := Turn{Content: contentText(im["content"])}
+				t.Role, _ = im["role"].(string)
+				turns = append(turns, t)
+			}
+		}
+	}
+	return turns
+}
+
+// functionCall reads a {"name", "arguments"} function call
+func functionCall(v interface{}) ToolCall {
+	m, _ := v.(map[string]interface{})
+	var tc ToolCall
+	tc.Name, _ = m["name"].(string)
+	tc.Arguments, _ = m["arguments"].(string)
+	return tc
+}
+
+// contentText returns the text of a message content, either a string or a list of parts of which the
+// text ones are kept, such as {"type": "text", "text": "..."}. Images and audio are dropped.
+func contentText(content interface{}) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []interface{}:
+		var parts []string
+		for _, p := range c {
+			switch pm := p.(type) {
+			case string:
+				parts = append(parts, pm)
+			case map[string]interface{}:
+				if s, ok := pm["text"].(string); ok && s != "" {
+					parts = append(parts, s)
+				}
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	return ""
+}
+
+// conversationText joins the content of the turns, which is what the semantic cache embeds
+func conversationText(turns []Turn) string {
+	var parts []string
+	for _, t := range turns {
+		if t.Content != "" {
+			parts = append(parts, t.Content)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// inputText joins the content and tool calls of every turn. The client sends the whole conversation, earlier
+// user turns, assistant turns and their tool calls included, so none of it can be trusted.
+func inputText(turns []Turn) string {
+	var parts []string
+	for _, t := range turns {
+		if t.Content != "" {
+			parts = append(parts, t.Content)
+		}
+		for _, tc := range t.ToolCalls {
+			parts = append(parts, tc.String())
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// latestInput returns the text the client added since the last assistant turn: the latest user turn and
+// the tool results that follow it
+func latestInput(turns []Turn) string {
+	// an assistant prefill ends the conversation without closing the user turn
+	for len(turns) > 0 && turns[len(turns)-1].Role == openai.ChatMessageRoleAssistant {
+		turns = turns[:len(turns)-1]
+	}
+	start := 0
+	for i, t := range turns {
+		if t.Role == openai.ChatMessageRoleAssistant {
+			start = i + 1
+		}
+	}
+	var parts []string
+	for _, t := range turns[start:] {
+		if (t.Role == openai.ChatMessageRoleUser || t.Role == openai.ChatMessageRoleTool) && t.Content != "" {
+			parts = append(parts, t.Content)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// systemText joins the system and developer turns, the context of the conversation
+func systemText(turns []Turn) string {
+	var parts []string
+	for _, t := range turns {
+		if (t.Role == openai.ChatMessageRoleSystem || t.Role == openai.ChatMessageRoleDeveloper) && t.Content != "" {
+			parts = append(parts, t.Content)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// dialogue returns the user and assistant turns as alternating messages starting with the user, the way
+// the guard models expect a conversation. Tool results count as user input, tool calls are rendered in the
+// assistant message, and consecutive messages of a role are merged.
+func dialogue(turns []Turn) []openai.ChatCompletionMessage {
+	var msgs []openai.ChatCompletionMessage
+	for _, t := range turns {
+		role := t.Role
+		var text []string
+		switch role {
+		case openai.ChatMessageRoleUser, openai.ChatMessageRoleTool:
+			role = openai.ChatMessageRoleUser
+		case openai.ChatMessageRoleAssistant:
+			if len(msgs) == 0 {
+				continue
+			}
+		default:
+			continue
+		}
+		if t.Content != "" {
+			text = append(text, t.Content)
+		}
+		for _, tc := range t.ToolCalls {
+			text = append(text, tc.String())
+		}
+		if len(text) == 0 {
+			continue
+		}
+		content := strings.Join(text, "\n")
+		if n := len(msgs); n > 0 && msgs[n-1].Role == role {
+			msgs[n-1].Content += "\n\n" + content
+			continue
+		}
+		msgs = append(msgs, openai.ChatCompletionMessage{Role: role, Content: content})
+	}
+	// an assistant prefill is not part of what the guard judges
+	for len(msgs) > 0 && msgs[len(msgs)-1].Role == openai.ChatMessageRoleAssistant {
+		msgs = msgs[:len(msgs)-1]
+	}
+	return msgs
+}
@@ -0,0 +1,86 @@
+package ext_proc
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sashabaranov/go-openai"
+)
+
+var _ = Describe("Conversation", func() {
+	parse := func(body string) []Turn {
+		var bodyMap map[string]interface{}
+		Expect(json.Unmarshal([]byte(body), &bodyMap)).To(Succeed())
+		return extractConversation(bodyMap)
+	}
+
+	It("should read chat messages with content parts and tool calls", func() {
+		turns := parse(`{"messages": [
+			{"role": "developer", "content": "Be brief."},
+			{"role": "user", "content": [{"type": "text", "text": "Look at this"}, {"type": "image_url", "image_url": {"url": "data:"}}, {"type": "text", "text": "and book it"}]},
+			{"role": "assistant", "content": "Booking.", "tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "book", "arguments": "{}"}}]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "Booked"},
+			{"role": "assistant", "function_call": {"name": "pay", "arguments": "{\"eur\":10}"}},
+			{"role": "function", "name": "pay", "content": "Paid"}]}`)
+		Expect(turns).To(Equal([]Turn{
+			{Role: "developer", Content: "Be brief."},
+			{Role: "user", Content: "Look at this\nand book it"},
+			{Role: "assistant", Content: "Booking.", ToolCalls: []ToolCall{{ID: "call_1", Name: "book", Arguments: "{}"}}},
+			{Role: "tool", Content: "Booked", ToolCallID: "call_1"},
+			{Role: "assistant", ToolCalls: []ToolCall{{Name: "pay", Arguments: `{"eur":10}`}}},
+			{Role: "tool", Content: "Paid"},
+		}))
+		Expect(latestInput(turns)).To(Equal("Paid"))
+		Expect(systemText(turns)).To(Equal("Be brief."))
+	})
+
+	It("should read Responses API instructions and input items", func() {
+		turns := parse(`{"instructions": "Be brief.", "input": [
+			{"role": "user", "content": [{"type": "input_text", "text": "Weather in Paris?"}]},
+			{"type": "function_call", "call_id": "call_1", "name": "get_weather", "arguments": "{}"},
+			{"type": "function_call_output", "call_id": "call_1", "output": "Sunny"}]}`)
+		Expect(turns).To(Equal([]Turn{
+			{Role: "system", Content: "Be brief."},
+			{Role: "user", Content: "Weather in Paris?"},
+			{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1", Name: "get_weather", Arguments: "{}"}}},
+			{Role: "tool", Content: "Sunny", ToolCallID: "call_1"},
+		}))
+		prompt, err := extractPrompt(map[string]interface{}{"input": []interface{}{map[string]interface{}{"role": "user", "content": "Hi"}}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(prompt).To(Equal("Hi"))
+	})
+
+	It("should read completion prompts", func() {
+		Expect(parse(`{"prompt": "Once upon a time"}`)).To(Equal([]Turn{{Role: "user", Content: "Once upon a time"}}))
+		Expect(parse(`{"prompt": ["One", "Two"]}`)).To(Equal([]Turn{{Role: "user", Content: "One\nTwo"}}))
+	})
+
+	It("should take the latest input since the last assistant turn", func() {
+		turns := []Turn{
+			{Role: "user", Content: "Hi"},
+			{Role: "assistant", Content: "Hello"},
+			{Role: "user", Content: "Tell me a secret"},
+			{Role: "user", Content: "Now"},
+			{Role: "assistant", Content: "Sure, the secret is"},
+		}
+		Expect(latestInput(turns)).To(Equal("Tell me a secret\nNow"))
+	})
+
+	It("should build an alternating dialogue starting with the user", func() {
+		msgs := dialogue([]Turn{
+			{Role: "system", Content: "Be brief."},
+			{Role: "assistant", Content: "Welcome!"},
+			{Role: "user", Content: "Book a table"},
+			{Role: "assistant", ToolCalls: []ToolCall{{Name: "book", Arguments: `{"seats":2}`}}},
+			{Role: "tool", Content: "Booked"},
+			{Role: "user", Content: "Thanks"},
+			{Role: "assistant", Content: "You're"},
+		})
+		Expect(msgs).To(Equal([]openai.ChatCompletionMessage{
+			{Role: "user", Content: "Book a table"},
+			{Role: "assistant", Content: `book({"seats":2})`},
+			{Role: "user", Content: "Booked\n\nThanks"},
+		}))
+	})
+})
@@ -0,0 +1,200 @@
+package ext_proc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchingEmbeddingProvider groups texts requested within a short window into a single call to the
+// wrapped provider, and shares in-flight results between callers asking for the same text
+type BatchingEmbeddingProvider struct {
+	next     EmbeddingProvider
+	window   time.Duration
+	maxBatch int
+
+	mu       sync.Mutex
+	inflight map[string]*embeddingCall
+	pending  []*embeddingCall
+	timer    *time.Timer
+}
+
+// embeddingCall is one distinct text waiting for its embedding, done is closed once emb or err is set.
+// ctx is the context of the caller that asked for it first, waiters and batch are guarded by the provider's mutex.
+type embeddingCall struct {
+	text    string
+	ctx     context.Context
+	done    chan struct{}
+	emb     []float64
+	err     error
+	waiters int
+	batch   *embeddingBatch
+}
+
+// embeddingBatch is a request sent to the wrapped provider, cancelled once none of its calls has a waiter left
+type embeddingBatch struct {
+	calls  []*embeddingCall
+	cancel context.CancelFunc
+}
+
+// abandoned reports whether every caller waiting on the batch has given up, b.mu must be held
+func (eb *embeddingBatch) abandoned() bool {
+	for _, c := range eb.calls {
+		if c.waiters > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// NewBatchingEmbeddingProvider wraps next. A batch is sent window after its first text arrives or as soon as
+// it holds maxBatch texts (default 32), a window <= 0 sends every call straight away and only deduplicates.
+func NewBatchingEmbeddingProvider(next EmbeddingProvider, window time.Duration, maxBatch int) *BatchingEmbeddingProvider {
+	if maxBatch <= 0 {
+		maxBatch = 32
+	}
+	return &BatchingEmbeddingProvider{
+		next:     next,
+		window:   window,
+		maxBatch: maxBatch,
+		inflight: map[string]*embeddingCall{},
+	}
+}
+
+func (b *BatchingEmbeddingProvider) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	calls := make([]*embeddingCall, len(texts))
+	var ready [][]*embeddingCall
+
+	b.mu.Lock()
+	for i, t := range texts {
+		if c, ok := b.inflight[t]; ok {
+			c.waiters++
+			calls[i] = c
+			continue
+		}
+		c := &embeddingCall{text: t, ctx: ctx, done: make(chan struct{}), waiters: 1}
+		b.inflight[t] = c
+		b.pending = append(b.pending, c)
+		calls[i] = c
+		if len(b.pending) >= b.maxBatch {
+			ready = append(ready, b.takePending())
+		}
+	}
+	if len(b.pending) > 0 {
+		if b.window <= 0 {
+			ready = append(ready, b.takePending())
+		} else if b.timer == nil {
+			b.timer = time.AfterFunc(b.window, b.flushPending)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, batch := range ready {
+		go b.send(batch)
+	}
+
+	embs := make([][]float64, len(calls))
+	for i, c := range calls {
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			b.leave(calls[i:])
+			return nil, ctx.Err()
+		}
+		if c.err != nil {
+			return nil, c.err
+		}
+		embs[i] = c.emb
+	}
+	return embs, nil
+}
+
+// leave stops waiting on calls, cancelling the batches no caller waits on anymore. Their texts are no longer
+// in flight, so later callers start a new request instead of sharing the cancelled one.
+func (b *BatchingEmbeddingProvider) leave(calls []*embeddingCall) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range calls {
+		c.waiters--
+		if c.batch == nil || !c.batch.abandoned() {
+			continue
+		}
+		c.batch.cancel()
+		for _, bc := range c.batch.calls {
+			b.forget(bc)
+		}
+	}
+}
+
+// forget removes c from the in-flight calls unless a newer call took its place, b.mu must be held
+func (b *BatchingEmbeddingProvider) forget(c *embeddingCall) {
+	if b.inflight[c.text] == c {
+		delete(b.inflight, c.text)
+	}
+}
+
+// takePending detaches the pending batch, b.mu must be held
+func (b *BatchingEmbeddingProvider) takePending() []*embeddingCall {
+	batch := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return batch
+}
+
+func (b *BatchingEmbeddingProvider) flushPending() {
+	b.mu.Lock()
+	batch := b.takePending()
+	b.mu.Unlock()
+	if len(batch) > 0 {
+		b.send(batch)
+	}
+}
+
+// send embeds the batch and completes its calls. The request runs in the trace of the first caller, it is not
+// cancelled when that caller gives up but once every caller waiting on the batch has, and is otherwise bounded
+// by the wrapped provider's timeout.
+func (b *BatchingEmbeddingProvider) send(batch []*embeddingCall) {
+	texts := make([]string, len(batch))
+	for i, c := range batch {
+		texts[i] = c.text
+	}
+	ctx, cancel := context.WithCancel(context.WithoutCancel(batch[0].ctx))
+	defer cancel()
+	eb := &embeddingBatch{calls: batch, cancel: cancel}
+	b.mu.Lock()
+	for _, c := range batch {
+		c.batch = eb
+	}
+	if eb.abandoned() {
+		cancel()
+	}
+	b.mu.Unlock()
+
+	var embs [][]float64
+	err := ctx.Err()
+	if err == nil {
+		embs, err = b.next.Embed(ctx, texts)
+	}
+	if err == nil && len(embs) != len(batch) {
+		err = fmt.Errorf("embedding provider returned %d embeddings for %d inputs", len(embs), len(batch))
+	}
+
+	b.mu.Lock()
+	for i, c := range batch {
+		if err != nil {
+			c.err = err
+		} else {
+			c.emb = embs[i]
+		}
+		b.forget(c)
+	}
+	b.mu.Unlock()
+
+	for _, c := range batch {
+		close(c.done)
+	}
+}
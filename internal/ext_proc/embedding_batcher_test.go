@@ -0,0 +1,168 @@
+package ext_proc_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kuadrant/inferno/internal/ext_proc"
+)
+
+// recordingEmbeddings returns the text length as a one dimensional embedding and records each batch
+type recordingEmbeddings struct {
+	mu      sync.Mutex
+	batches [][]string
+	delay   time.Duration
+	err     error
+}
+
+func (r *recordingEmbeddings) Embed(_ context.Context, texts []string) ([][]float64, error) {
+	r.mu.Lock()
+	r.batches = append(r.batches, texts)
+	r.mu.Unlock()
+	time.Sleep(r.delay)
+	if r.err != nil {
+		return nil, r.err
+	}
+	embs := make([][]float64, len(texts))
+	for i, t := range texts {
+		embs[i] = []float64{float64(len(t))}
+	}
+	return embs, nil
+}
+
+func (r *recordingEmbeddings) calls() [][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]string(nil), r.batches...)
+}
+
+// blockingEmbeddings holds every request until its context is done and reports the context it was given
+type blockingEmbeddings struct {
+	requests chan context.Context
+}
+
+func (b *blockingEmbeddings) Embed(ctx context.Context, _ []string) ([][]float64, error) {
+	b.requests <- ctx
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+type callerKey struct{}
+
+var _ = Describe("BatchingEmbeddingProvider", func() {
+	var next *recordingEmbeddings
+
+	BeforeEach(func() {
+		next = &recordingEmbeddings{}
+	})
+
+	// embedConcurrently embeds each text from its own goroutine and returns the results in order
+	embedConcurrently := func(p ext_proc.EmbeddingProvider, texts ...string) [][]float64 {
+		out := make([][]float64, len(texts))
+		var wg sync.WaitGroup
+		for i, t := range texts {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				embs, err := p.Embed(context.Background(), []string{t})
+				Expect(err).NotTo(HaveOccurred())
+				out[i] = embs[0]
+			}()
+		}
+		wg.Wait()
+		return out
+	}
+
+	It("should group prompts arriving within the window into one request", func() {
+		p := ext_proc.NewBatchingEmbeddingProvider(next, 50*time.Millisecond, 32)
+		out := embedConcurrently(p, "a", "bb", "ccc")
+		Expect(out).To(Equal([][]float64{{1}, {2}, {3}}))
+		Expect(next.calls()).To(HaveLen(1))
+		Expect(next.calls()[0]).To(ConsistOf("a", "bb", "ccc"))
+	})
+
+	It("should send a batch as soon as it is full", func() {
+		p := ext_proc.NewBatchingEmbeddingProvider(next, time.Hour, 2)
+		embs, err := p.Embed(context.Background(), []string{"a", "bb", "ccc", "dddd"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(embs).To(Equal([][]float64{{1}, {2}, {3}, {4}}))
+		Expect(next.calls()).To(ConsistOf([]string{"a", "bb"}, []string{"ccc", "dddd"}))
+	})
+
+	It("should share one request between identical in-flight prompts", func() {
+		next.delay = 20 * time.Millisecond
+		p := ext_proc.NewBatchingEmbeddingProvider(next, 0, 32)
+		out := embedConcurrently(p, "same", "same", "same", "same")
+		for _, emb := range out {
+			Expect(emb).To(Equal([]float64{4}))
+		}
+		Expect(next.calls()).To(HaveLen(1))
+		Expect(next.calls()[0]).To(Equal([]string{"same"}))
+	})
+
+	It("should fetch a prompt again once the previous request completed", func() {
+		p := ext_proc.NewBatchingEmbeddingProvider(next, 0, 32)
+		_, err := p.Embed(context.Background(), []string{"a"})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = p.Embed(context.Background(), []string{"a"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(next.calls()).To(HaveLen(2))
+	})
+
+	It("should return the provider error to every caller in the batch", func() {
+		next.err = errors.New("model not loaded")
+		p := ext_proc.NewBatchingEmbeddingProvider(next, 10*time.Millisecond, 32)
+		var wg sync.WaitGroup
+		for _, t := range []string{"a", "b"} {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, err := p.Embed(context.Background(), []string{t})
+				Expect(err).To(MatchError("model not loaded"))
+			}()
+		}
+		wg.Wait()
+	})
+
+	It("should cancel the request once every caller has given up", func() {
+		next := &blockingEmbeddings{requests: make(chan context.Context, 1)}
+		p := ext_proc.NewBatchingEmbeddingProvider(next, 50*time.Millisecond, 32)
+		first, cancelFirst := context.WithCancel(context.WithValue(context.Background(), callerKey{}, "first"))
+		second, cancelSecond := context.WithCancel(context.Background())
+		errs := make(chan error, 2)
+		for _, ctx := range []context.Context{first, second} {
+			go func() {
+				_, err := p.Embed(ctx, []string{"slow"})
+				errs <- err
+			}()
+			time.Sleep(5 * time.Millisecond)
+		}
+		var req context.Context
+		Eventually(next.requests).Should(Receive(&req))
+		Expect(req.Value(callerKey{})).To(Equal("first"))
+
+		cancelFirst()
+		Eventually(errs).Should(Receive(MatchError(context.Canceled)))
+		Consistently(req.Done(), 50*time.Millisecond).ShouldNot(BeClosed())
+
+		cancelSecond()
+		Eventually(errs).Should(Receive(MatchError(context.Canceled)))
+		Eventually(req.Done()).Should(BeClosed())
+	})
+
+	It("should stop waiting when the caller's context is done", func() {
+		next.delay = time.Second
+		p := ext_proc.NewBatchingEmbeddingProvider(next, 0, 32)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := p.Embed(ctx, []string{"slow"})
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+	})
+})
@@ -0,0 +1,288 @@
+package ext_proc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/kuadrant/inferno/internal/config"
+)
+
+// EmbeddingProvider turns texts into embedding vectors, one per text and in the same order
+type EmbeddingProvider interface {
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// embedding provider names accepted by EMBEDDING_PROVIDER
+const (
+	EmbeddingProviderKServeV1 = "kserve-v1"
+	EmbeddingProviderKServeV2 = "kserve-v2"
+	EmbeddingProviderOpenAI   = "openai"
+	EmbeddingProviderTEI      = "tei"
+)
+
+type EmbeddingProviderConfig struct {
+	// Provider selects the wire protocol, defaults to kserve-v1. local needs no URL.
+	Provider string
+	// URL is the full inference endpoint, e.g. http://host/v1/models/embedding-model:predict
+	URL string
+	// Host overrides the Host header, for gateways that route on it
+	Host string
+	// Model is sent as the model name by the openai provider
+	Model string
+	// APIKey is sent as a bearer token when set
+	APIKey string
+	// InputName is the tensor name used by the kserve-v2 provider
+	InputName string
+	// Dimensions is the vector size of the local provider
+	Dimensions int
+	Timeout    time.Duration
+	// BatchWindow is how long remote requests wait for other prompts to share a batch, zero only deduplicates
+	BatchWindow time.Duration
+	// MaxBatch caps the number of prompts sent in one request
+	MaxBatch int
+}
+
+func embeddingProviderConfig(c config.EmbeddingConfig) EmbeddingProviderConfig {
+	return EmbeddingProviderConfig{
+		Provider:    c.Provider,
+		URL:         c.URL,
+		Host:        c.Host,
+		Model:       c.Model,
+		APIKey:      c.APIKey,
+		InputName:   c.InputName,
+		Dimensions:  c.Dimensions,
+		Timeout:     time.Duration(c.Timeout),
+		BatchWindow: time.Duration(c.BatchWindow),
+		MaxBatch:    c.BatchMaxSize,
+	}
+}
+
+// NewEmbeddingProvider builds the provider selected by cfg.Provider. The local provider is only
+// used when selected, without a server URL the other providers fail and the cache is disabled.
+// Remote providers are wrapped in a BatchingEmbeddingProvider.
+func NewEmbeddingProvider(cfg EmbeddingProviderConfig) (EmbeddingProvider, error) {
+	if cfg.Provider == EmbeddingProviderLocal {
+		return newLocalEmbeddings(cfg.Dimensions), nil
+	}
+	p, err := newRemoteEmbeddingProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewBatchingEmbeddingProvider(p, cfg.BatchWindow, cfg.MaxBatch), nil
+}
+
+// embeddingProviderName returns the provider NewEmbeddingProvider selects for cfg
+func embeddingProviderName(cfg EmbeddingProviderConfig) string {
+	if cfg.Provider != "" {
+		return cfg.Provider
+	}
+	return EmbeddingProviderKServeV1
+}
+
+func newRemoteEmbeddingProvider(cfg EmbeddingProviderConfig) (EmbeddingProvider, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("embedding provider %q requires a server URL", cfg.Provider)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	c := &embeddingHTTPClient{
+		url:    cfg.URL,
+		host:   cfg.Host,
+		apiKey: cfg.APIKey,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+
+	switch cfg.Provider {
+	case "", EmbeddingProviderKServeV1:
+		return &kserveV1Embeddings{c}, nil
+	case EmbeddingProviderKServeV2:
+		name := cfg.InputName
+		if name == "" {
+			name = "text"
+		}
+		return &kserveV2Embeddings{embeddingHTTPClient: c, inputName: name}, nil
+	case EmbeddingProviderOpenAI:
+		return &openAIEmbeddings{embeddingHTTPClient: c, model: cfg.Model}, nil
+	case EmbeddingProviderTEI:
+		return &teiEmbeddings{c}, nil
+	default:
+		return nil, fmt.Errorf("unknown embedding provider %q", cfg.Provider)
+	}
+}
+
+// embeddingHTTPClient holds the endpoint details shared by the HTTP based providers
+type embeddingHTTPClient struct {
+	url    string
+	host   string
+	apiKey string
+	client *http.Client
+}
+
+func (c *embeddingHTTPClient) postJSON(ctx context.Context, in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("create embedding request: %w", err)
+	}
+	if c.host != "" {
+		httpReq.Host = c.host
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	httpResp, err := c.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("fetch embedding: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	body, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return fmt.Errorf("read embedding response: %w", err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("embedding server returned %s: %s", httpResp.Status, truncate(string(body), 200))
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("decode embedding response: %w", err)
+	}
+	return nil
+}
+
+func checkEmbeddingCount(got [][]float64, want int) ([][]float64, error) {
+	if len(got) != want {
+		return nil, fmt.Errorf("embedding server returned %d embeddings for %d inputs", len(got), want)
+	}
+	return got, nil
+}
+
+// kserveV1Embeddings speaks the KServe v1 protocol
+// ref: https://kserve.github.io/website/latest/modelserving/data_plane/v1_protocol/
+type kserveV1Embeddings struct {
+	*embeddingHTTPClient
+}
+
+func (k *kserveV1Embeddings) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	var out struct {
+		Predictions [][]float64 `json:"predictions"`
+	}
+	if err := k.postJSON(ctx, map[string]interface{}{"instances": texts}, &out); err != nil {
+		return nil, err
+	}
+	return checkEmbeddingCount(out.Predictions, len(texts))
+}
+
+// kserveV2Embeddings speaks the Open Inference Protocol, sending the texts as a BYTES tensor
+// ref: https://kserve.github.io/website/latest/modelserving/data_plane/v2_protocol/
+type kserveV2Embeddings struct {
+	*embeddingHTTPClient
+	inputName string
+}
+
+func (k *kserveV2Embeddings) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	in := map[string]interface{}{
+		"inputs": []map[string]interface{}{{
+			"name":     k.inputName,
+			"shape":    []int{len(texts)},
+			"datatype": "BYTES",
+			"data":     texts,
+		}},
+	}
+	var out struct {
+		Outputs []struct {
+			Name  string          `json:"name"`
+			Shape []int           `json:"shape"`
+			Data  json.RawMessage `json:"data"`
+		} `json:"outputs"`
+	}
+	if err := k.postJSON(ctx, in, &out); err != nil {
+		return nil, err
+	}
+	if len(out.Outputs) == 0 {
+		return nil, fmt.Errorf("embedding server returned no outputs")
+	}
+	o := out.Outputs[0]
+
+	// data is usually flattened in row-major order, but some servers return nested rows
+	var nested [][]float64
+	if err := json.Unmarshal(o.Data, &nested); err == nil {
+		return checkEmbeddingCount(nested, len(texts))
+	}
+	var flat []float64
+	if err := json.Unmarshal(o.Data, &flat); err != nil {
+		return nil, fmt.Errorf("decode output tensor %q: %w", o.Name, err)
+	}
+	if len(o.Shape) != 2 || o.Shape[0]*o.Shape[1] != len(flat) {
+		return nil, fmt.Errorf("output tensor %q has shape %v for %d values", o.Name, o.Shape, len(flat))
+	}
+	rows := make([][]float64, o.Shape[0])
+	for i := range rows {
+		rows[i] = flat[i*o.Shape[1] : (i+1)*o.Shape[1]]
+	}
+	return checkEmbeddingCount(rows, len(texts))
+}
+
+// openAIEmbeddings speaks the OpenAI compatible /v1/embeddings API, also served by vLLM and KServe's huggingface runtime
+// ref: https://platform.openai.com/docs/api-reference/embeddings/create
+type openAIEmbeddings struct {
+	*embeddingHTTPClient
+	model string
+}
+
+func (o *openAIEmbeddings) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	in := map[string]interface{}{
+		"input":           texts,
+		"encoding_format": "float",
+	}
+	if o.model != "" {
+		in["model"] = o.model
+	}
+	var out struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := o.postJSON(ctx, in, &out); err != nil {
+		return nil, err
+	}
+	sort.Slice(out.Data, func(i, j int) bool { return out.Data[i].Index < out.Data[j].Index })
+	embs := make([][]float64, len(out.Data))
+	for i, d := range out.Data {
+		embs[i] = d.Embedding
+	}
+	return checkEmbeddingCount(embs, len(texts))
+}
+
+// teiEmbeddings speaks the Hugging Face text-embeddings-inference /embed API
+// ref: https://huggingface.github.io/text-embeddings-inference/
+type teiEmbeddings struct {
+	*embeddingHTTPClient
+}
+
+func (t *teiEmbeddings) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	var out [][]float64
+	if err := t.postJSON(ctx, map[string]interface{}{"inputs": texts}, &out); err != nil {
+		return nil, err
+	}
+	return checkEmbeddingCount(out, len(texts))
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
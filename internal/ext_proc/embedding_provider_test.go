@@ -0,0 +1,151 @@
+package ext_proc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kuadrant/inferno/internal/ext_proc"
+)
+
+var _ = Describe("EmbeddingProvider", func() {
+	var (
+		received map[string]interface{}
+		header   http.Header
+		host     string
+	)
+
+	// serve starts a server that records the request and replies with reply
+	serve := func(reply string) string {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received = nil
+			Expect(json.NewDecoder(r.Body).Decode(&received)).To(Succeed())
+			header, host = r.Header, r.Host
+			w.Write([]byte(reply))
+		}))
+		DeferCleanup(srv.Close)
+		return srv.URL
+	}
+
+	embed := func(cfg ext_proc.EmbeddingProviderConfig, texts ...string) ([][]float64, error) {
+		p, err := ext_proc.NewEmbeddingProvider(cfg)
+		Expect(err).NotTo(HaveOccurred())
+		return p.Embed(context.Background(), texts)
+	}
+
+	It("should speak the KServe v1 protocol by default", func() {
+		url := serve(`{"predictions": [[1, 2], [3, 4]]}`)
+		embs, err := embed(ext_proc.EmbeddingProviderConfig{URL: url, Host: "embedding.example.com"}, "a", "b")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(embs).To(Equal([][]float64{{1, 2}, {3, 4}}))
+		Expect(received).To(HaveKeyWithValue("instances", ConsistOf("a", "b")))
+		Expect(host).To(Equal("embedding.example.com"))
+	})
+
+	It("should speak the KServe v2 protocol with flattened output", func() {
+		url := serve(`{"outputs": [{"name": "embedding", "shape": [2, 2], "datatype": "FP32", "data": [1, 2, 3, 4]}]}`)
+		embs, err := embed(ext_proc.EmbeddingProviderConfig{Provider: "kserve-v2", URL: url}, "a", "b")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(embs).To(Equal([][]float64{{1, 2}, {3, 4}}))
+		inputs := received["inputs"].([]interface{})
+		Expect(inputs[0]).To(HaveKeyWithValue("name", "text"))
+		Expect(inputs[0]).To(HaveKeyWithValue("datatype", "BYTES"))
+		Expect(inputs[0]).To(HaveKeyWithValue("shape", ConsistOf(BeNumerically("==", 2))))
+		Expect(inputs[0]).To(HaveKeyWithValue("data", ConsistOf("a", "b")))
+	})
+
+	It("should reject a KServe v2 output that does not match its shape", func() {
+		url := serve(`{"outputs": [{"name": "embedding", "shape": [2, 3], "datatype": "FP32", "data": [1, 2, 3, 4]}]}`)
+		_, err := embed(ext_proc.EmbeddingProviderConfig{Provider: "kserve-v2", URL: url}, "a", "b")
+		Expect(err).To(MatchError(ContainSubstring("shape")))
+	})
+
+	It("should speak the OpenAI embeddings API and order results by index", func() {
+		url := serve(`{"data": [{"index": 1, "embedding": [3, 4]}, {"index": 0, "embedding": [1, 2]}]}`)
+		embs, err := embed(ext_proc.EmbeddingProviderConfig{Provider: "openai", URL: url, Model: "text-embedding-3-small", APIKey: "secret"}, "a", "b")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(embs).To(Equal([][]float64{{1, 2}, {3, 4}}))
+		Expect(received).To(HaveKeyWithValue("model", "text-embedding-3-small"))
+		Expect(received).To(HaveKeyWithValue("input", ConsistOf("a", "b")))
+		Expect(header.Get("Authorization")).To(Equal("Bearer secret"))
+	})
+
+	It("should speak the TEI embed API", func() {
+		url := serve(`[[1, 2]]`)
+		embs, err := embed(ext_proc.EmbeddingProviderConfig{Provider: "tei", URL: url}, "a")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(embs).To(Equal([][]float64{{1, 2}}))
+		Expect(received).To(HaveKeyWithValue("inputs", ConsistOf("a")))
+	})
+
+	It("should fail when the server returns fewer embeddings than inputs", func() {
+		url := serve(`{"predictions": [[1, 2]]}`)
+		_, err := embed(ext_proc.EmbeddingProviderConfig{URL: url}, "a", "b")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should surface server errors", func() {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "model not loaded", http.StatusServiceUnavailable)
+		}))
+		defer srv.Close()
+		_, err := embed(ext_proc.EmbeddingProviderConfig{URL: srv.URL}, "a")
+		Expect(err).To(MatchError(ContainSubstring("model not loaded")))
+	})
+
+	Context("local provider", func() {
+		var local ext_proc.EmbeddingProvider
+
+		BeforeEach(func() {
+			var err error
+			local, err = ext_proc.NewEmbeddingProvider(ext_proc.EmbeddingProviderConfig{Provider: "local"})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		similarity := func(a, b string) float64 {
+			embs, err := local.Embed(context.Background(), []string{a, b})
+			Expect(err).NotTo(HaveOccurred())
+			var dot float64
+			for i := range embs[0] {
+				dot += embs[0][i] * embs[1][i]
+			}
+			return dot
+		}
+
+		It("should only be used when selected", func() {
+			embs, err := local.Embed(context.Background(), []string{"What is Kubernetes?"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(embs[0]).To(HaveLen(512))
+
+			_, err = ext_proc.NewEmbeddingProvider(ext_proc.EmbeddingProviderConfig{})
+			Expect(err).To(MatchError(ContainSubstring("requires a server URL")))
+		})
+
+		It("should produce deterministic unit vectors", func() {
+			Expect(similarity("What is Kubernetes?", "What is Kubernetes?")).To(BeNumerically("~", 1.0, 1e-9))
+		})
+
+		It("should rank paraphrases above unrelated prompts", func() {
+			related := similarity("What is Kubernetes?", "what's kubernetes")
+			unrelated := similarity("What is Kubernetes?", "Write a poem about the sea")
+			Expect(related).To(BeNumerically(">", 0.5))
+			Expect(related).To(BeNumerically(">", unrelated+0.3))
+		})
+
+		It("should honour the configured dimensions", func() {
+			p, err := ext_proc.NewEmbeddingProvider(ext_proc.EmbeddingProviderConfig{Provider: "local", Dimensions: 64})
+			Expect(err).NotTo(HaveOccurred())
+			embs, _ := p.Embed(context.Background(), []string{"hello"})
+			Expect(embs[0]).To(HaveLen(64))
+		})
+	})
+
+	It("should reject an unknown provider", func() {
+		_, err := ext_proc.NewEmbeddingProvider(ext_proc.EmbeddingProviderConfig{Provider: "word2vec", URL: "http://localhost"})
+		Expect(err).To(HaveOccurred())
+	})
+})
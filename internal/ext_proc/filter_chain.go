@@ -0,0 +1,438 @@
+package ext_proc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	filterPb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelCodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kuadrant/inferno/internal/metrics"
+	"github.com/kuadrant/inferno/internal/tracing"
+)
+
+// Filter is one stage of the processing chain. Hooks run in chain order for each phase of a request, and
+// per-request state belongs in the RequestContext since a filter is shared by all streams.
+// Embed BaseFilter to only implement the hooks a filter needs.
+type Filter interface {
+	Name() string
+	OnRequestHeaders(rc *RequestContext) PhaseResult
+	// OnRequestBody runs once the request body is buffered, rc holds the parsed body and prompt
+	OnRequestBody(rc *RequestContext, body []byte) PhaseResult
+	OnResponseHeaders(rc *RequestContext, headers map[string]string) PhaseResult
+	// OnResponseBody runs once with the whole body for buffered responses, and for every chunk of an
+	// event stream, whose text so far is accumulated in rc
+	OnResponseBody(rc *RequestContext, body []byte, endOfStream bool) PhaseResult
+	// OnResponseTrailers only runs for event streams, trailers are how they report data known at the end
+	OnResponseTrailers(rc *RequestContext) PhaseResult
+}
+
+// PhaseResult is a filter's decision for one phase, the zero value lets the request continue unchanged
+type PhaseResult struct {
+	SetHeaders    []*configPb.HeaderValueOption
+	RemoveHeaders []string
+	// Body replaces the body, or the current chunk of an event stream, when non-nil
+	Body []byte
+	// Immediate answers the client directly, skipping the remaining filters and the upstream
+	Immediate *extProcPb.ImmediateResponse
+	// Stop skips the remaining filters for this phase
+	Stop bool
+}
+
+// BaseFilter implements every Filter hook as a no-op
+type BaseFilter struct{}
+
+func (BaseFilter) OnRequestHeaders(*RequestContext) PhaseResult { return PhaseResult{} }
+
+func (BaseFilter) OnRequestBody(*RequestContext, []byte) PhaseResult { return PhaseResult{} }
+
+func (BaseFilter) OnResponseHeaders(*RequestContext, map[string]string) PhaseResult {
+	return PhaseResult{}
+}
+
+func (BaseFilter) OnResponseBody(*RequestContext, []byte, bool) PhaseResult { return PhaseResult{} }
+
+func (BaseFilter) OnResponseTrailers(*RequestContext) PhaseResult { return PhaseResult{} }
+
+// Chain runs an ordered list of filters over ext_proc streams
+type Chain struct {
+	// filters is swapped by SetFilters, each request keeps the list it started with
+	filters atomic.Pointer[[]Filter]
+	// strict fails the stream on bodies that are not JSON or carry no prompt, instead of passing them through
+	strict bool
+	// requests holds the *RequestContext of in-flight requests by request ID
+	requests sync.Map
+}
+
+func NewChain(filters ...Filter) *Chain {
+	c := &Chain{}
+	c.SetFilters(filters...)
+	return c
+}
+
+// SetFilters replaces the filters for new requests, requests in flight finish with the previous filters
+func (c *Chain) SetFilters(filters ...Filter) {
+	c.filters.Store(&filters)
+}
+
+// Filters returns the filter names in chain order
+func (c *Chain) Filters() []string {
+	filters := *c.filters.Load()
+	names := make([]string, len(filters))
+	for i, f := range filters {
+		names[i] = f.Name()
+	}
+	return names
+}
+
+// ActiveRequests returns the number of requests currently being processed
+func (c *Chain) ActiveRequests() int {
+	n := 0
+	c.requests.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func (c *Chain) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
+	log := logger("chain")
+	log.Debug("Starting processing loop", "filters", c.Filters())
+
+	// rc is created with the request headers and released when the stream ends, however it ends
+	var rc *RequestContext
+	defer func() {
+		if rc != nil {
+			c.requests.CompareAndDelete(rc.id, rc)
+			rc.endTrace()
+			log.InfoContext(rc.trace, "Request finished", "duration", time.Since(rc.start), "route", rc.route.Name, "model", rc.model, "cache", string(rc.cache.status))
+		}
+	}()
+	begin := func(headers map[string]string) {
+		if rc != nil {
+			c.requests.CompareAndDelete(rc.id, rc)
+			rc.endTrace()
+		}
+		rc = c.newRequest(headers)
+		rc.startTrace()
+		c.requests.Store(rc.id, rc)
+	}
+
+	for {
+		req, err := srv.Recv()
+		if err == io.EOF {
+			log.Debug("Received EOF, terminating processing loop")
+			return nil
+		}
+		if err != nil {
+			if status.Code(err) == codes.Canceled || errors.Is(err, context.Canceled) {
+				log.Debug("Stream cancelled, finishing up")
+				return nil
+			}
+			log.Error("Error receiving request", "error", err)
+			return err
+		}
+
+		// the request headers may not be sent to us, correlate on what we have
+		if _, ok := req.Request.(*extProcPb.ProcessingRequest_RequestHeaders); ok || rc == nil {
+			begin(headerMap(req.GetRequestHeaders().GetHeaders().GetHeaders()))
+		}
+		rc.route.update(req)
+
+		start := time.Now()
+		span := rc.startPhase(phaseName(req))
+		log.DebugContext(rc.ctx, "Processing phase", "phase", phaseName(req))
+		resp, err := c.handle(rc, req)
+		endPhase(span, resp, err)
+		metrics.PhaseDuration.WithLabelValues(phaseName(req)).Observe(time.Since(start).Seconds())
+		if err != nil {
+			return err
+		}
+
+		if err := srv.Send(resp); err != nil {
+			if status.Code(err) == codes.Canceled || errors.Is(err, context.Canceled) {
+				log.DebugContext(rc.ctx, "Stream canceled, exiting cleanly")
+				return nil
+			}
+			log.ErrorContext(rc.ctx, "Error sending response", "error", err)
+			return status.Errorf(codes.Unknown, "cannot send stream response: %v", err)
+		}
+	}
+}
+
+// newRequest creates the context of a request, which runs the filters current at its start
+func (c *Chain) newRequest(headers map[string]string) *RequestContext {
+	rc := newRequestContext(headers)
+	rc.filters = *c.filters.Load()
+	return rc
+}
+
+// handle runs the filters for one ext_proc message and builds the response
+func (c *Chain) handle(rc *RequestContext, req *extProcPb.ProcessingRequest) (*extProcPb.ProcessingResponse, error) {
+	switch r := req.Request.(type) {
+	case *extProcPb.ProcessingRequest_RequestHeaders:
+		logger("chain").DebugContext(rc.ctx, "Request started", "route", rc.route.Name)
+		res := c.run(rc, func(f Filter) PhaseResult { return f.OnRequestHeaders(rc) })
+		if res.Immediate != nil {
+			return immediate(res.Immediate), nil
+		}
+		return &extProcPb.ProcessingResponse{
+			Response: &extProcPb.ProcessingResponse_RequestHeaders{
+				RequestHeaders: &extProcPb.HeadersResponse{Response: res.common()},
+			},
+		}, nil
+
+	case *extProcPb.ProcessingRequest_RequestBody:
+		var res PhaseResult
+		if r.RequestBody.EndOfStream {
+			if err := c.parseRequest(rc, r.RequestBody.Body); err != nil {
+				return nil, err
+			}
+			res = c.run(rc, func(f Filter) PhaseResult { return f.OnRequestBody(rc, r.RequestBody.Body) })
+		}
+		if res.Immediate != nil {
+			return immediate(res.Immediate), nil
+		}
+		return &extProcPb.ProcessingResponse{
+			Response: &extProcPb.ProcessingResponse_RequestBody{
+				RequestBody: &extProcPb.BodyResponse{Response: res.common()},
+			},
+		}, nil
+
+	case *extProcPb.ProcessingRequest_ResponseHeaders:
+		headers := headerMap(r.ResponseHeaders.GetHeaders().GetHeaders())
+		// the filters need the whole response, so we want to buffer the body,
+		// except for event streams which are inspected chunk by chunk as they are forwarded
+		mode := &filterPb.ProcessingMode{
+			ResponseHeaderMode: filterPb.ProcessingMode_SKIP,
+			ResponseBodyMode:   filterPb.ProcessingMode_BUFFERED,
+		}
+		if isEventStream(headers) {
+			logger("chain").DebugContext(rc.ctx, "Event stream response, switching to streamed mode")
+			rc.stream = &sseAccumulator{}
+			mode.ResponseBodyMode = filterPb.ProcessingMode_STREAMED
+			// usage only arrives with the last chunk, after the headers are gone, so it is sent as trailers
+			mode.ResponseTrailerMode = filterPb.ProcessingMode_SEND
+		}
+		res := c.run(rc, func(f Filter) PhaseResult { return f.OnResponseHeaders(rc, headers) })
+		if res.Immediate != nil {
+			return immediate(res.Immediate), nil
+		}
+		return &extProcPb.ProcessingResponse{
+			Response: &extProcPb.ProcessingResponse_ResponseHeaders{
+				ResponseHeaders: &extProcPb.HeadersResponse{Response: res.common()},
+			},
+			ModeOverride: mode,
+		}, nil
+
+	case *extProcPb.ProcessingRequest_ResponseBody:
+		body := r.ResponseBody
+		var res PhaseResult
+		switch {
+		case rc.stream != nil:
+			rc.stream.Write(body.Body)
+			if body.EndOfStream {
+				rc.stream.Close()
+			}
+			res = c.run(rc, func(f Filter) PhaseResult { return f.OnResponseBody(rc, body.Body, body.EndOfStream) })
+		case body.EndOfStream:
+			if err := c.parseResponse(rc, body.Body); err != nil {
+				return nil, err
+			}
+			res = c.run(rc, func(f Filter) PhaseResult { return f.OnResponseBody(rc, body.Body, true) })
+		}
+		if res.Immediate != nil {
+			return immediate(res.Immediate), nil
+		}
+		return &extProcPb.ProcessingResponse{
+			Response: &extProcPb.ProcessingResponse_ResponseBody{
+				ResponseBody: &extProcPb.BodyResponse{Response: res.common()},
+			},
+		}, nil
+
+	case *extProcPb.ProcessingRequest_RequestTrailers:
+		return &extProcPb.ProcessingResponse{
+			Response: &extProcPb.ProcessingResponse_RequestTrailers{
+				RequestTrailers: &extProcPb.TrailersResponse{},
+			},
+		}, nil
+
+	case *extProcPb.ProcessingRequest_ResponseTrailers:
+		trailers := &extProcPb.TrailersResponse{}
+		if rc.stream != nil {
+			res := c.run(rc, func(f Filter) PhaseResult { return f.OnResponseTrailers(rc) })
+			if len(res.SetHeaders) > 0 || len(res.RemoveHeaders) > 0 {
+				trailers.HeaderMutation = &extProcPb.HeaderMutation{SetHeaders: res.SetHeaders, RemoveHeaders: res.RemoveHeaders}
+			}
+		}
+		return &extProcPb.ProcessingResponse{
+			Response: &extProcPb.ProcessingResponse_ResponseTrailers{
+				ResponseTrailers: trailers,
+			},
+		}, nil
+
+	default:
+		logger("chain").WarnContext(rc.ctx, "Unrecognized request type", "type", fmt.Sprintf("%T", req.Request))
+		return &extProcPb.ProcessingResponse{}, nil
+	}
+}
+
+// startTrace starts the span of the stream, continuing the trace of the traceparent request header
+func (rc *RequestContext) startTrace() {
+	parent := otel.GetTextMapPropagator().Extract(rc.trace, propagation.MapCarrier(rc.headers))
+	rc.trace, _ = tracing.Tracer().Start(parent, "ext_proc",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("inferno.request_id", rc.id)))
+	rc.ctx = rc.trace
+}
+
+// endTrace ends the stream span with what was learned about the request
+func (rc *RequestContext) endTrace() {
+	span := trace.SpanFromContext(rc.trace)
+	if rc.route.Name != "" {
+		span.SetAttributes(attribute.String("inferno.route", rc.route.Name))
+	}
+	if rc.model != "" {
+		span.SetAttributes(attribute.String("inferno.model", rc.model))
+	}
+	if rc.cache.status != "" {
+		span.SetAttributes(attribute.String("inferno.cache", string(rc.cache.status)))
+	}
+	span.End()
+}
+
+// startPhase starts the span of an ext_proc message as a child of the stream span, filters see it
+// through rc.Context
+func (rc *RequestContext) startPhase(phase string) trace.Span {
+	var span trace.Span
+	rc.ctx, span = tracing.Tracer().Start(rc.trace, "ext_proc "+phase)
+	return span
+}
+
+// endPhase ends a phase span, recording the error or the status of an immediate response
+func endPhase(span trace.Span, resp *extProcPb.ProcessingResponse, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelCodes.Error, err.Error())
+	} else if ir := resp.GetImmediateResponse(); ir != nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", int(ir.GetStatus().GetCode())))
+	}
+	span.End()
+}
+
+// phaseName labels the ext_proc message in metrics and traces
+func phaseName(req *extProcPb.ProcessingRequest) string {
+	switch req.Request.(type) {
+	case *extProcPb.ProcessingRequest_RequestHeaders:
+		return "request_headers"
+	case *extProcPb.ProcessingRequest_RequestBody:
+		return "request_body"
+	case *extProcPb.ProcessingRequest_RequestTrailers:
+		return "request_trailers"
+	case *extProcPb.ProcessingRequest_ResponseHeaders:
+		return "response_headers"
+	case *extProcPb.ProcessingRequest_ResponseBody:
+		return "response_body"
+	case *extProcPb.ProcessingRequest_ResponseTrailers:
+		return "response_trailers"
+	default:
+		return "unknown"
+	}
+}
+
+// run calls hook for each filter in order and merges the results, stopping at the first immediate response
+func (c *Chain) run(rc *RequestContext, hook func(Filter) PhaseResult) PhaseResult {
+	var merged PhaseResult
+	for _, f := range rc.filters {
+		res := hook(f)
+		if res.Immediate != nil {
+			logger("chain").DebugContext(rc.ctx, "Filter answered the request", "filter", f.Name())
+			return res
+		}
+		merged.SetHeaders = append(merged.SetHeaders, res.SetHeaders...)
+		merged.RemoveHeaders = append(merged.RemoveHeaders, res.RemoveHeaders...)
+		if res.Body != nil {
+			merged.Body = res.Body
+		}
+		if res.Stop {
+			logger("chain").DebugContext(rc.ctx, "Filter stopped the chain", "filter", f.Name())
+			break
+		}
+	}
+	return merged
+}
+
+// parseRequest parses the buffered request body and extracts the prompt and conversation into rc
+func (c *Chain) parseRequest(rc *RequestContext, body []byte) error {
+	var bodyMap map[string]interface{}
+	if err := json.Unmarshal(body, &bodyMap); err != nil {
+		logger("chain").DebugContext(rc.ctx, "Failed to parse request body", "error", err)
+		if c.strict {
+			return status.Errorf(codes.InvalidArgument, "invalid request body: %v", err)
+		}
+		return nil
+	}
+	rc.request = bodyMap
+	rc.model, _ = bodyMap["model"].(string)
+
+	prompt, err := extractPrompt(bodyMap)
+	if err != nil {
+		logger("chain").DebugContext(rc.ctx, "No prompt in request body", "error", err)
+		if c.strict {
+			return status.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		return nil
+	}
+	rc.prompt = prompt
+	rc.conversation = extractConversation(bodyMap)
+	return nil
+}
+
+// parseResponse parses a buffered JSON response body into rc
+func (c *Chain) parseResponse(rc *RequestContext, body []byte) error {
+	var respData map[string]interface{}
+	if err := json.Unmarshal(body, &respData); err != nil {
+		logger("chain").DebugContext(rc.ctx, "Failed to parse response body", "error", err)
+		if c.strict {
+			return status.Errorf(codes.InvalidArgument, "invalid response body: %v", err)
+		}
+		return nil
+	}
+	rc.response = respData
+	return nil
+}
+
+// common returns the mutations of a phase result, nil when there are none
+func (res PhaseResult) common() *extProcPb.CommonResponse {
+	if len(res.SetHeaders) == 0 && len(res.RemoveHeaders) == 0 && res.Body == nil {
+		return nil
+	}
+	common := &extProcPb.CommonResponse{}
+	if len(res.SetHeaders) > 0 || len(res.RemoveHeaders) > 0 {
+		common.HeaderMutation = &extProcPb.HeaderMutation{SetHeaders: res.SetHeaders, RemoveHeaders: res.RemoveHeaders}
+	}
+	if res.Body != nil {
+		common.BodyMutation = &extProcPb.BodyMutation{Mutation: &extProcPb.BodyMutation_Body{Body: res.Body}}
+	}
+	return common
+}
+
+func immediate(ir *extProcPb.ImmediateResponse) *extProcPb.ProcessingResponse {
+	return &extProcPb.ProcessingResponse{
+		Response: &extProcPb.ProcessingResponse_ImmediateResponse{ImmediateResponse: ir},
+	}
+}
@@ -0,0 +1,143 @@
+package ext_proc
+
+import (
+	"context"
+
+	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	typePb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kuadrant/inferno/internal/config"
+)
+
+// recordingFilter records the hooks it sees and returns a fixed result from OnRequestBody
+type recordingFilter struct {
+	BaseFilter
+	name   string
+	calls  *[]string
+	result PhaseResult
+}
+
+func (f *recordingFilter) Name() string { return f.name }
+
+func (f *recordingFilter) OnRequestBody(rc *RequestContext, body []byte) PhaseResult {
+	*f.calls = append(*f.calls, f.name+":"+rc.Prompt())
+	return f.result
+}
+
+var _ = Describe("Chain", func() {
+	var calls []string
+
+	filter := func(name string, result PhaseResult) Filter {
+		return &recordingFilter{name: name, calls: &calls, result: result}
+	}
+	header := func(key, value string) []*configPb.HeaderValueOption {
+		return []*configPb.HeaderValueOption{{Header: &configPb.HeaderValue{Key: key, RawValue: []byte(value)}}}
+	}
+	requestBody := func(body string) *extProcPb.ProcessingRequest {
+		return &extProcPb.ProcessingRequest{Request: &extProcPb.ProcessingRequest_RequestBody{
+			RequestBody: &extProcPb.HttpBody{Body: []byte(body), EndOfStream: true},
+		}}
+	}
+
+	BeforeEach(func() {
+		calls = nil
+	})
+
+	It("should run filters in order and merge their mutations", func() {
+		c := NewChain(
+			filter("a", PhaseResult{SetHeaders: header("x-a", "1")}),
+			filter("b", PhaseResult{SetHeaders: header("x-b", "2"), RemoveHeaders: []string{"x-c"}}),
+		)
+		Expect(c.Filters()).To(Equal([]string{"a", "b"}))
+
+		resp, err := c.handle(c.newRequest(nil), requestBody(`{"prompt":"hello"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(calls).To(Equal([]string{"a:hello", "b:hello"}))
+		mutation := resp.GetRequestBody().GetResponse().GetHeaderMutation()
+		Expect(mutation.GetSetHeaders()).To(HaveLen(2))
+		Expect(mutation.GetRemoveHeaders()).To(Equal([]string{"x-c"}))
+	})
+
+	It("should skip the remaining filters after an immediate response", func() {
+		c := NewChain(
+			filter("a", PhaseResult{Immediate: &extProcPb.ImmediateResponse{Status: &typePb.HttpStatus{Code: typePb.StatusCode_Forbidden}}}),
+			filter("b", PhaseResult{}),
+		)
+		resp, err := c.handle(c.newRequest(nil), requestBody(`{"prompt":"hello"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(calls).To(Equal([]string{"a:hello"}))
+		Expect(resp.GetImmediateResponse().GetStatus().GetCode()).To(Equal(typePb.StatusCode_Forbidden))
+	})
+
+	It("should skip the remaining filters when one stops the chain", func() {
+		c := NewChain(
+			filter("a", PhaseResult{Body: []byte("replaced"), Stop: true}),
+			filter("b", PhaseResult{Body: []byte("ignored")}),
+		)
+		resp, err := c.handle(c.newRequest(nil), requestBody(`{"prompt":"hello"}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(calls).To(Equal([]string{"a:hello"}))
+		Expect(string(resp.GetRequestBody().GetResponse().GetBodyMutation().GetBody())).To(Equal("replaced"))
+	})
+
+	It("should pass bodies without a prompt through unless strict", func() {
+		c := NewChain(filter("a", PhaseResult{}))
+		_, err := c.handle(c.newRequest(nil), requestBody(`not json`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(calls).To(Equal([]string{"a:"}))
+
+		c.strict = true
+		_, err = c.handle(c.newRequest(nil), requestBody(`not json`))
+		Expect(err).To(MatchError(ContainSubstring("invalid request body")))
+	})
+})
+
+var _ = Describe("Processor filters", func() {
+	var p *Processor
+
+	BeforeEach(func() {
+		p = &Processor{promptGuard: &PromptGuard{}, semanticCache: &SemanticCache{}, tokenMetrics: &TokenUsageMetrics{}}
+	})
+
+	It("should use the default order", func() {
+		Expect(NewChain(p.filtersFor(config.Default().Filters)...).Filters()).To(Equal([]string{"prompt-guard", "semantic-cache", "token-metrics"}))
+	})
+
+	It("should skip unknown or repeated names", func() {
+		names := []string{"token-metrics", "bogus", "prompt-guard", "token-metrics"}
+		Expect(NewChain(p.filtersFor(names)...).Filters()).To(Equal([]string{"token-metrics", "prompt-guard"}))
+	})
+
+	It("should not cache responses the guard rejected", func() {
+		GinkgoT().Setenv("SEMANTIC_CACHE_STORE", "memory")
+		GinkgoT().Setenv("SEMANTIC_CACHE_INDEX", "flat")
+		sc := NewSemanticCache()
+		defer sc.Close()
+		body := []byte(`{"choices": [{"text": "A risky answer."}]}`)
+		for _, d := range []guardDecision{guardBlock, guardDegradedBlock, guardFailClosed} {
+			rc := &RequestContext{ctx: context.Background(), prompt: "What is Kubernetes?", embedding: []float64{1, 0}}
+			rc.response = map[string]interface{}{"choices": []interface{}{map[string]interface{}{"text": "A risky answer."}}}
+			rc.guard.response = d
+			sc.OnResponseBody(rc, body, true)
+		}
+		e, _ := sc.findMostSimilarPrompt(context.Background(), "", []float64{1, 0})
+		Expect(e).To(BeNil())
+	})
+
+	It("should keep the filters of requests in flight when the chain changes", func() {
+		var calls []string
+		c := NewChain(&recordingFilter{name: "a", calls: &calls})
+		rc := c.newRequest(nil)
+
+		c.SetFilters(&recordingFilter{name: "b", calls: &calls})
+		_, err := c.handle(rc, &extProcPb.ProcessingRequest{Request: &extProcPb.ProcessingRequest_RequestBody{
+			RequestBody: &extProcPb.HttpBody{Body: []byte(`{"prompt":"hello"}`), EndOfStream: true},
+		}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(calls).To(Equal([]string{"a:hello"}))
+		Expect(c.Filters()).To(Equal([]string{"b"}))
+	})
+})
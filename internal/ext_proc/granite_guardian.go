@@ -0,0 +1,157 @@
+package ext_proc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	otelCodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/kuadrant/inferno/internal/logging"
+	"github.com/kuadrant/inferno/internal/metrics"
+	"github.com/kuadrant/inferno/internal/tracing"
+)
+
+// GraniteGuardian checks the risks of the input, see guardianRisks, with one call to the model per risk
+type GraniteGuardian struct {
+	name       string
+	client     OpenAIChatCompleter
+	model      string
+	riskyToken string
+}
+
+// NewGraniteGuardian returns a backend calling model through client, granite-guardian by default
+func NewGraniteGuardian(name string, client OpenAIChatCompleter, model string) *GraniteGuardian {
+	if model == "" {
+		model = "granite-guardian"
+	}
+	return &GraniteGuardian{name: name, client: client, model: model, riskyToken: "Yes"}
+}
+
+func (g *GraniteGuardian) Name() string {
+	return g.name
+}
+
+// Check returns the risks the model flagged. When none was, the checks that gave no verdict are
+// reported as an error.
+func (g *GraniteGuardian) Check(ctx context.Context, in GuardInput) ([]string, error) {
+	return flagged(g.assess(ctx, in))
+}
+
+// Ping lists the models rather than running the model
+func (g *GraniteGuardian) Ping(ctx context.Context) error {
+	return pingModels(ctx, g.client)
+}
+
+// riskVerdict is the outcome of the check of one risk, err is set when the guardian gave no verdict
+type riskVerdict struct {
+	risk  string
+	risky bool
+	err   error
+}
+
+// flagged returns the risks flagged by the verdicts. When none was, the checks that gave no verdict
+// are reported as an error.
+func flagged(verdicts []riskVerdict) ([]string, error) {
+	var risks []string
+	var errs []error
+	for _, v := range verdicts {
+		switch {
+		case v.err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", v.risk, v.err))
+		case v.risky:
+			risks = append(risks, v.risk)
+		}
+	}
+	if len(risks) > 0 {
+		return risks, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// assess checks each risk of the input concurrently, skipping the risks it lacks the messages for, such
+// as groundedness without a context
+func (g *GraniteGuardian) assess(ctx context.Context, in GuardInput) []riskVerdict {
+	log := logger("prompt_guard")
+	results := make([]riskVerdict, len(in.Risks))
+	var wg sync.WaitGroup
+	for i, name := range in.Risks {
+		risk, ok := guardianRisks[name]
+		if !ok {
+			log.WarnContext(ctx, "Skipping unknown risk", "risk", name)
+			continue
+		}
+		msgs, ok := risk.messages(in)
+		if !ok {
+			log.DebugContext(ctx, "Skipping risk, the request lacks what it judges", "risk", name)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			risky, err := g.checkRisk(ctx, name, msgs)
+			results[i] = riskVerdict{risk: name, risky: risky, err: err}
+		}()
+	}
+	wg.Wait()
+
+	verdicts := results[:0]
+	for _, v := range results {
+		if v.risk != "" {
+			verdicts = append(verdicts, v)
+		}
+	}
+	return verdicts
+}
+
+// checkRisk asks the guardian about one risk, an error means it gave no verdict: the call failed or
+// timed out, or the answer had no choices
+func (g *GraniteGuardian) checkRisk(ctx context.Context, risk string, msgs []openai.ChatCompletionMessage) (bool, error) {
+	log := logger("prompt_guard")
+	ctx, span := tracing.Tracer().Start(ctx, "prompt_guard.check_risk",
+		trace.WithAttributes(
+			attribute.String("inferno.guardian.model", g.model),
+			attribute.String("inferno.guardian.risk", risk),
+		))
+	defer span.End()
+
+	log.DebugContext(ctx, "Checking risk", "risk", risk, "text", logging.Text(msgs[len(msgs)-1].Content), "model", g.model)
+
+	start := time.Now()
+	resp, err := g.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:       g.model,
+		Messages:    msgs,
+		Temperature: 0.01,
+		MaxTokens:   50,
+	})
+	metrics.GuardianDuration.WithLabelValues(metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelCodes.Error, err.Error())
+		if status.Code(err) == codes.Canceled {
+			log.WarnContext(ctx, "Risk check canceled by context", "risk", risk)
+			return false, err
+		}
+		log.ErrorContext(ctx, "Risk model call failed", "risk", risk, "error", err)
+		return false, err
+	}
+
+	if len(resp.Choices) == 0 {
+		log.WarnContext(ctx, "No choices in risk model response", "risk", risk)
+		return false, errors.New("no choices in risk model response")
+	}
+	result := strings.TrimSpace(resp.Choices[0].Message.Content)
+	log.DebugContext(ctx, "Risk model responded", "risk", risk, "result", result)
+
+	risky := strings.EqualFold(result, g.riskyToken)
+	span.SetAttributes(attribute.Bool("inferno.guardian.risky", risky))
+	return risky, nil
+}
@@ -0,0 +1,248 @@
+package ext_proc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/kuadrant/inferno/internal/config"
+	"github.com/kuadrant/inferno/internal/metrics"
+)
+
+// GuardBackend judges a prompt or response for the prompt guard
+type GuardBackend interface {
+	// Name labels the backend in logs and metrics
+	Name() string
+	// Check returns the risk categories found in the text of the input's direction, none when it is safe.
+	// An error means the backend gave no verdict.
+	Check(ctx context.Context, in GuardInput) ([]string, error)
+}
+
+// GuardInput is the part of the conversation a guard check judges
+type GuardInput struct {
+	// Direction is prompt or response, the text judged
+	Direction string
+	// Conversation is the conversation of the request the judged text belongs to, when known
+	Conversation []Turn
+	// Prompt is the latest input of the conversation, the user turn and tool results since the last
+	// assistant turn
+	Prompt string
+	// Response is the generated text, empty for prompt checks
+	Response string
+	// Context is the system text of the request, the grounding documents of groundedness checks
+	Context string
+	// Tools is the JSON tool definitions of the request
+	Tools string
+	// ToolCalls are the tool calls of the response
+	ToolCalls []ToolCall
+	// Risks are the Granite Guardian risks the route checks, backends with their own taxonomy ignore them
+	Risks []string
+
+	// route labels the verdict metrics
+	route string
+}
+
+// text returns the text of the input's direction: for prompts every turn of the conversation, for responses
+// the generated text, each followed by their tool calls written as name(arguments)
+func (in GuardInput) text() string {
+	var parts []string
+	if in.Direction != "response" {
+		if len(in.Conversation) > 0 {
+			return inputText(in.Conversation)
+		}
+		return in.promptText()
+	}
+	if in.Response != "" {
+		parts = append(parts, in.Response)
+	}
+	for _, tc := range in.ToolCalls {
+		parts = append(parts, tc.String())
+	}
+	return strings.Join(parts, "\n")
+}
+
+// promptText joins the system text and the latest input. Clients can send their own system and developer
+// messages, so those are judged with every prompt rather than trusted.
+func (in GuardInput) promptText() string {
+	if in.Context == "" {
+		return in.Prompt
+	}
+	if in.Prompt == "" {
+		return in.Context
+	}
+	return in.Context + "\n\n" + in.Prompt
+}
+
+// dialogue returns the conversation up to the judged text, ending with the latest input, preceded by the
+// system text, for prompt checks and with the response for response checks
+func (in GuardInput) dialogue() []openai.ChatCompletionMessage {
+	msgs := dialogue(in.Conversation)
+	if len(msgs) == 0 && in.Prompt != "" {
+		msgs = append(msgs, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: in.Prompt})
+	}
+	if in.Direction != "response" && in.Context != "" {
+		// the guard models judge the last user message, so the system text goes there
+		if n := len(msgs); n > 0 {
+			msgs[n-1].Content = in.Context + "\n\n" + msgs[n-1].Content
+		} else {
+			msgs = append(msgs, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: in.Context})
+		}
+	}
+	if text := in.text(); in.Direction == "response" && text != "" {
+		msgs = append(msgs, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: text})
+	}
+	return msgs
+}
+
+// checkBackend runs a backend check and counts its verdict
+func checkBackend(ctx context.Context, b GuardBackend, in GuardInput) ([]string, error) {
+	flagged, err := b.Check(ctx, in)
+	if err == nil {
+		verdict := "safe"
+		if len(flagged) > 0 {
+			verdict = "risky"
+		}
+		metrics.GuardVerdicts.WithLabelValues(in.route, in.Direction, b.Name(), verdict).Inc()
+	}
+	return flagged, err
+}
+
+// Voting modes of VotingGuard
+const (
+	VoteAny      = "any"
+	VoteAll      = "all"
+	VoteMajority = "majority"
+)
+
+// VotingGuard combines backends checked concurrently. A text is flagged when any, all or the majority of
+// the backends flag it, with the categories of those that did. Backends that give no verdict don't vote,
+// and when the missing votes could change the outcome the voting gives no verdict either.
+type VotingGuard struct {
+	backends []GuardBackend
+	mode     string
+}
+
+// NewVotingGuard combines backends with a voting mode: any, all or majority
+func NewVotingGuard(mode string, backends ...GuardBackend) *VotingGuard {
+	return &VotingGuard{backends: backends, mode: mode}
+}
+
+func (v *VotingGuard) Name() string {
+	return "vote-" + v.mode
+}
+
+func (v *VotingGuard) Check(ctx context.Context, in GuardInput) ([]string, error) {
+	type vote struct {
+		flagged []string
+		err     error
+	}
+	votes := make([]vote, len(v.backends))
+	var wg sync.WaitGroup
+	for i, b := range v.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			flagged, err := checkBackend(ctx, b, in)
+			votes[i] = vote{flagged, err}
+		}()
+	}
+	wg.Wait()
+
+	var categories []string
+	var errs []error
+	risky, safe := 0, 0
+	for i, vt := range votes {
+		switch {
+		case vt.err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", v.backends[i].Name(), vt.err))
+		case len(vt.flagged) > 0:
+			risky++
+			for _, c := range vt.flagged {
+				if !slices.Contains(categories, c) {
+					categories = append(categories, c)
+				}
+			}
+		default:
+			safe++
+		}
+	}
+
+	n, missing := len(v.backends), len(errs)
+	var flagged, decided bool
+	switch v.mode {
+	case VoteAll:
+		flagged, decided = risky == n, risky == n || safe > 0
+	case VoteMajority:
+		flagged, decided = 2*risky > n, 2*risky > n || 2*(risky+missing) <= n
+	default:
+		flagged, decided = risky > 0, risky > 0 || missing == 0
+	}
+	if !decided {
+		return nil, errors.Join(errs...)
+	}
+	if !flagged {
+		return nil, nil
+	}
+	return categories, nil
+}
+
+// Ping checks every backend that can be checked
+func (v *VotingGuard) Ping(ctx context.Context) error {
+	var errs []error
+	for _, b := range v.backends {
+		if p, ok := b.(pinger); ok {
+			if err := p.Ping(ctx); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", b.Name(), err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pinger is implemented by backends whose health can be checked
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// pingModels lists the models of an OpenAI compatible client rather than running one, clients that can't
+// list models are assumed healthy
+func pingModels(ctx context.Context, client interface{}) error {
+	lister, ok := client.(interface {
+		ListModels(ctx context.Context) (openai.ModelsList, error)
+	})
+	if !ok {
+		return nil
+	}
+	_, err := lister.ListModels(ctx)
+	return err
+}
+
+// newGuardBackend builds a configured backend
+func newGuardBackend(cfg config.GuardBackendConfig) (GuardBackend, error) {
+	name := cfg.Name
+	if name == "" {
+		name = cfg.Type
+	}
+	client := func() *openai.Client {
+		oc := openai.DefaultConfig(cfg.APIKey)
+		oc.BaseURL = cfg.URL
+		return openai.NewClientWithConfig(oc)
+	}
+	switch cfg.Type {
+	case "granite-guardian":
+		return NewGraniteGuardian(name, client(), cfg.Model), nil
+	case "llama-guard":
+		return NewLlamaGuard(name, client(), cfg.Model), nil
+	case "openai-moderation":
+		return NewOpenAIModeration(name, client(), cfg.Model), nil
+	case "rules":
+		return NewRuleGuard(name, cfg.Rules)
+	default:
+		return nil, fmt.Errorf("unknown guard backend type %q", cfg.Type)
+	}
+}
@@ -0,0 +1,86 @@
+package ext_proc
+
+import (
+	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+
+	"github.com/kuadrant/inferno/internal/config"
+	"github.com/kuadrant/inferno/internal/metrics"
+)
+
+const (
+	guardPromptHeader   = "x-inferno-guard-prompt"
+	guardResponseHeader = "x-inferno-guard-response"
+	// guardRisksHeader lists the risks the guardian flagged on a blocked request
+	guardRisksHeader = "x-inferno-guard-risks"
+)
+
+// guardDecision is what the guard did with a checked text
+type guardDecision string
+
+const (
+	// guardAllow and guardBlock follow the guardian's verdict
+	guardAllow guardDecision = "allow"
+	guardBlock guardDecision = "block"
+	// the others apply the failure policy when the guardian gave no verdict
+	guardFailOpen      guardDecision = "fail-open"
+	guardFailClosed    guardDecision = "fail-closed"
+	guardDegradedAllow guardDecision = "degraded-allow"
+	guardDegradedBlock guardDecision = "degraded-block"
+)
+
+// blocks reports whether the text is rejected as risky
+func (d guardDecision) blocks() bool {
+	return d == guardBlock || d == guardDegradedBlock
+}
+
+// guardResult records the decisions of the prompt and response checks of a request, reported back in
+// the response headers
+type guardResult struct {
+	prompt   guardDecision
+	response guardDecision
+	// risks are the risks flagged by the check that blocked the request
+	risks []string
+	// checked is the size of the stream output at its last response check, cut is set once the stream was
+	// terminated and its remaining chunks are dropped
+	checked int
+	cut     bool
+}
+
+// header returns the decision header of a direction, nil when that direction was not checked
+func (gr guardResult) header(direction string) []*configPb.HeaderValueOption {
+	if direction == "prompt" && gr.prompt != "" {
+		return []*configPb.HeaderValueOption{cacheHeader(guardPromptHeader, string(gr.prompt))}
+	}
+	if direction == "response" && gr.response != "" {
+		return []*configPb.HeaderValueOption{cacheHeader(guardResponseHeader, string(gr.response))}
+	}
+	return nil
+}
+
+// set records the decision of a direction and counts it in the guard metrics. An event stream is checked
+// repeatedly, only its final response decision is counted, by finish.
+func (gr *guardResult) set(rc *RequestContext, direction string, d guardDecision) {
+	if direction == "prompt" {
+		gr.prompt = d
+	} else {
+		gr.response = d
+	}
+	if direction == "prompt" || rc.stream == nil {
+		metrics.GuardDecisions.WithLabelValues(rc.route.Name, direction, string(d)).Inc()
+	}
+}
+
+// finish counts the final response decision of an event stream
+func (gr *guardResult) finish(rc *RequestContext) {
+	if gr.response != "" {
+		metrics.GuardDecisions.WithLabelValues(rc.route.Name, "response", string(gr.response)).Inc()
+	}
+}
+
+// failurePolicy returns the configured policy of a direction: open, closed or degrade
+func failurePolicy(cfg *config.PromptGuardConfig, direction string) string {
+	if direction == "prompt" {
+		return cfg.OnFailure.Prompt
+	}
+	return cfg.OnFailure.Response
+}
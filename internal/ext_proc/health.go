@@ -0,0 +1,51 @@
+package ext_proc
+
+import (
+	"context"
+	"errors"
+)
+
+// Health service names of the processor dependencies
+const (
+	HealthEmbedding  = "inferno.embedding"
+	HealthGuardian   = "inferno.guardian"
+	HealthCacheStore = "inferno.cache_store"
+)
+
+// HealthCheck probes a dependency, returning nil when it is healthy
+type HealthCheck func(ctx context.Context) error
+
+// HealthChecks returns a check for each dependency of the processor, keyed by health service name.
+// Dependencies that are not configured have no check.
+func (p *Processor) HealthChecks() map[string]HealthCheck {
+	checks := map[string]HealthCheck{
+		HealthCacheStore: p.semanticCache.store.Ping,
+	}
+	if p.semanticCache.embedder != nil {
+		checks[HealthEmbedding] = p.semanticCache.ping
+	}
+	if p.promptGuard.backend != nil {
+		checks[HealthGuardian] = p.promptGuard.ping
+	}
+	return checks
+}
+
+// ping embeds a short text, which is the only call every provider supports
+func (sc *SemanticCache) ping(ctx context.Context) error {
+	embs, err := sc.embedder.Embed(ctx, []string{"health check"})
+	if err != nil {
+		return err
+	}
+	if len(embs) == 0 || len(embs[0]) == 0 {
+		return errors.New("empty embedding")
+	}
+	return nil
+}
+
+// ping checks the guard backend, backends that can't be checked are assumed healthy
+func (pg *PromptGuard) ping(ctx context.Context) error {
+	if p, ok := pg.backend.(pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
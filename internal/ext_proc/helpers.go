@@ -1,24 +1,27 @@
 package ext_proc
 
 import (
-	"bytes"
-	"encoding/json"
-	"io"
-	"log"
-	"net/http"
+	"log/slog"
 	"time"
 
 	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
 	typeV3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+
+	"github.com/kuadrant/inferno/internal/config"
 )
 
 func createForbiddenResponse(message string) *extProcPb.ProcessingResponse {
+	return createErrorResponse(typeV3.StatusCode_Forbidden, message)
+}
+
+// createErrorResponse answers the client with status and a JSON error body
+func createErrorResponse(status typeV3.StatusCode, message string) *extProcPb.ProcessingResponse {
 	return &extProcPb.ProcessingResponse{
 		Response: &extProcPb.ProcessingResponse_ImmediateResponse{
 			ImmediateResponse: &extProcPb.ImmediateResponse{
 				Status: &typeV3.HttpStatus{
-					Code: typeV3.StatusCode_Forbidden,
+					Code: status,
 				},
 				Body: []byte(`{"error":"` + message + `"}`),
 				Headers: &extProcPb.HeaderMutation{
@@ -36,59 +39,32 @@ func createForbiddenResponse(message string) *extProcPb.ProcessingResponse {
 	}
 }
 
-func fetchEmbedding(embeddingServerURL, embeddingModelHost, prompt string) []float64 {
-	log.Printf("[SemanticCache] Cache miss, fetching embedding from %s", embeddingServerURL)
-	reqMap := map[string]interface{}{"instances": []string{prompt}}
-	data, _ := json.Marshal(reqMap)
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	httpReq, err := http.NewRequest("POST", embeddingServerURL, bytes.NewReader(data))
-	if err != nil {
-		log.Printf("[SemanticCache] HTTP request creation err: %v", err)
-		return nil
-	}
+// logger returns the default logger for a component, looked up on each use so it follows logging.Setup
+func logger(component string) *slog.Logger {
+	return slog.Default().With("component", component)
+}
 
-	if embeddingModelHost != "" {
-		httpReq.Host = embeddingModelHost
-		log.Printf("[SemanticCache] Set Host header: %s", embeddingModelHost)
+// configFromEnv returns the configuration from environment variables alone, for the constructors that
+// are not given one. Invalid values are logged and skipped.
+func configFromEnv() *config.Config {
+	cfg, errs := config.FromEnv()
+	for _, err := range errs {
+		logger("config").Warn("Ignoring invalid setting", "error", err)
 	}
+	return cfg
+}
 
-	httpReq.Header.Set("Content-Type", "application/json")
-
-	// cURL req for reproducing
-	log.Printf("[SemanticCache] curl -X %s '%s' -H 'Host: %s' -H 'Content-Type: application/json' --data '%s'",
-		httpReq.Method, httpReq.URL.String(), httpReq.Host, string(data))
-
-	httpResp, err := client.Do(httpReq)
-
+// cacheLimits converts configured cache limits, an invalid eviction policy falls back to LRU
+func cacheLimits(c config.CacheLimits) CacheLimits {
+	policy, err := ParseEvictionPolicy(c.Eviction)
 	if err != nil {
-		log.Printf("[SemanticCache][ERROR] Fetch embedding err: %v", err)
-		return nil
+		logger("config").Warn("Using lru eviction", "error", err)
+		policy = EvictionLRU
 	}
-	defer httpResp.Body.Close()
-
-	log.Printf("[SemanticCache] Embedding responded: %s", httpResp.Status)
-
-	if httpResp.StatusCode != http.StatusOK {
-		log.Printf("[SemanticCache] Unexpected status code: %d", httpResp.StatusCode)
-		if allow := httpResp.Header.Get("Allow"); allow != "" {
-			log.Printf("[SemanticCache] Allowed methods: %s", allow)
-		}
+	return CacheLimits{
+		TTL:        time.Duration(c.TTL),
+		MaxEntries: c.MaxEntries,
+		MaxBytes:   c.MaxBytes,
+		Policy:     policy,
 	}
-
-	b, _ := io.ReadAll(httpResp.Body)
-	log.Printf("[SemanticCache] Response body (%d bytes): %s", len(b), string(b))
-
-	var o struct{ Predictions [][]float64 }
-	if err := json.Unmarshal(b, &o); err != nil {
-		log.Printf("[SemanticCache][ERROR] JSON unmarshal err: %v", err)
-		return nil
-	}
-	if len(o.Predictions) == 0 {
-		log.Println("[SemanticCache] No predictions found in response")
-		return nil
-	}
-
-	emb := o.Predictions[0]
-	return emb
 }
@@ -0,0 +1,352 @@
+package ext_proc
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// HNSWConfig tunes the graph built by HNSWIndex
+// ref: https://arxiv.org/abs/1603.09320
+type HNSWConfig struct {
+	// M is the number of neighbours kept per node on the upper layers, layer 0 keeps 2*M
+	M int
+	// EfConstruction is the candidate list size used while inserting
+	EfConstruction int
+	// EfSearch is the candidate list size used while querying, higher is more accurate but slower
+	EfSearch int
+}
+
+func DefaultHNSWConfig() HNSWConfig {
+	return HNSWConfig{
+		M:              16,
+		EfConstruction: 200,
+		EfSearch:       64,
+	}
+}
+
+type hnswNode struct {
+	entry   *CacheEntry
+	vec     []float64 // unit-normalised copy of the embedding
+	links   [][]int   // neighbour ids per layer, len(links) = level+1
+	deleted bool
+}
+
+// HNSWIndex is an approximate nearest-neighbour index based on a hierarchical navigable small world graph.
+// Removed entries are tombstoned and the graph is rebuilt once tombstones outnumber live entries, a search
+// that only reaches tombstones until then falls back to scanning the live entries.
+type HNSWIndex struct {
+	mu        sync.RWMutex
+	cfg       HNSWConfig
+	levelMult float64
+	rng       *rand.Rand
+
+	nodes    []*hnswNode
+	ids      map[*CacheEntry]int
+	entry    int
+	maxLevel int
+	dim      int
+	deleted  int
+}
+
+func NewHNSWIndex(cfg HNSWConfig) *HNSWIndex {
+	def := DefaultHNSWConfig()
+	if cfg.M < 2 {
+		cfg.M = def.M
+	}
+	if cfg.EfConstruction < cfg.M {
+		cfg.EfConstruction = def.EfConstruction
+	}
+	if cfg.EfSearch < 1 {
+		cfg.EfSearch = def.EfSearch
+	}
+	h := &HNSWIndex{
+		cfg:       cfg,
+		levelMult: 1 / math.Log(float64(cfg.M)),
+		rng:       rand.New(rand.NewSource(rand.Int63())),
+	}
+	h.reset()
+	return h
+}
+
+func (h *HNSWIndex) reset() {
+	h.nodes = nil
+	h.ids = make(map[*CacheEntry]int)
+	h.entry = -1
+	h.maxLevel = 0
+	h.dim = 0
+	h.deleted = 0
+}
+
+// Add rejects embeddings whose dimension differs from the entries already indexed
+func (h *HNSWIndex) Add(e *CacheEntry) error {
+	if e == nil || len(e.Embedding) == 0 {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.ids[e]; ok {
+		return nil
+	}
+	if h.dim != 0 && len(e.Embedding) != h.dim {
+		return fmt.Errorf("embedding has %d dimensions, the index holds %d", len(e.Embedding), h.dim)
+	}
+	h.insert(e)
+	return nil
+}
+
+func (h *HNSWIndex) Remove(e *CacheEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	id, ok := h.ids[e]
+	if !ok {
+		return
+	}
+	delete(h.ids, e)
+	n := h.nodes[id]
+	n.deleted = true
+	n.entry = nil
+	h.deleted++
+
+	if len(h.ids) == 0 {
+		h.reset()
+		return
+	}
+	if h.deleted > len(h.ids) && h.deleted > h.cfg.EfConstruction {
+		h.rebuild()
+	}
+}
+
+func (h *HNSWIndex) Search(vec []float64) (*CacheEntry, float64) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.entry < 0 || len(vec) != h.dim {
+		return nil, 0
+	}
+	q := normalize(vec)
+	ep := h.entry
+	for l := h.maxLevel; l > 0; l-- {
+		ep = h.greedy(q, ep, l)
+	}
+	for _, c := range h.searchLayer(q, ep, h.cfg.EfSearch, 0) {
+		if n := h.nodes[c.id]; !n.deleted {
+			return n.entry, 1 - c.dist
+		}
+	}
+	// the beam only reached tombstones, which can happen before they are numerous enough to rebuild
+	return h.scan(q)
+}
+
+// scan compares the query with every live node
+func (h *HNSWIndex) scan(q []float64) (*CacheEntry, float64) {
+	var best *CacheEntry
+	bestDist := math.Inf(1)
+	for _, n := range h.nodes {
+		if n.deleted {
+			continue
+		}
+		if d := distance(q, n.vec); d < bestDist {
+			best, bestDist = n.entry, d
+		}
+	}
+	if best == nil {
+		return nil, 0
+	}
+	return best, 1 - bestDist
+}
+
+func (h *HNSWIndex) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.ids)
+}
+
+func (h *HNSWIndex) rebuild() {
+	live := make([]*CacheEntry, 0, len(h.ids))
+	for _, n := range h.nodes {
+		if !n.deleted {
+			live = append(live, n.entry)
+		}
+	}
+	h.reset()
+	for _, e := range live {
+		h.insert(e)
+	}
+}
+
+func (h *HNSWIndex) randomLevel() int {
+	// 1-Float64 is in (0, 1] so the log is always finite
+	return int(math.Floor(-math.Log(1-h.rng.Float64()) * h.levelMult))
+}
+
+func (h *HNSWIndex) maxLinks(level int) int {
+	if level == 0 {
+		return 2 * h.cfg.M
+	}
+	return h.cfg.M
+}
+
+func (h *HNSWIndex) insert(e *CacheEntry) {
+	level := h.randomLevel()
+	id := len(h.nodes)
+	n := &hnswNode{
+		entry: e,
+		vec:   normalize(e.Embedding),
+		links: make([][]int, level+1),
+	}
+	h.nodes = append(h.nodes, n)
+	h.ids[e] = id
+
+	if h.entry < 0 {
+		h.entry = id
+		h.maxLevel = level
+		h.dim = len(e.Embedding)
+		return
+	}
+
+	ep := h.entry
+	for l := h.maxLevel; l > level; l-- {
+		ep = h.greedy(n.vec, ep, l)
+	}
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		cands := h.searchLayer(n.vec, ep, h.cfg.EfConstruction, l)
+		neighbours := make([]int, 0, h.cfg.M)
+		for _, c := range cands {
+			if len(neighbours) == h.cfg.M {
+				break
+			}
+			neighbours = append(neighbours, c.id)
+		}
+		n.links[l] = neighbours
+		for _, nb := range neighbours {
+			h.link(nb, id, l)
+		}
+		ep = cands[0].id
+	}
+
+	if level > h.maxLevel {
+		h.maxLevel = level
+		h.entry = id
+	}
+}
+
+// link adds an edge from -> to on the given layer, pruning the farthest neighbours on overflow
+func (h *HNSWIndex) link(from, to, level int) {
+	n := h.nodes[from]
+	n.links[level] = append(n.links[level], to)
+	if len(n.links[level]) <= h.maxLinks(level) {
+		return
+	}
+	links := n.links[level]
+	sort.Slice(links, func(i, j int) bool {
+		return distance(n.vec, h.nodes[links[i]].vec) < distance(n.vec, h.nodes[links[j]].vec)
+	})
+	n.links[level] = links[:h.maxLinks(level)]
+}
+
+// greedy walks the layer towards q and returns the closest node it reaches
+func (h *HNSWIndex) greedy(q []float64, ep, level int) int {
+	best := ep
+	bestDist := distance(q, h.nodes[ep].vec)
+	for changed := true; changed; {
+		changed = false
+		for _, nb := range h.nodes[best].links[level] {
+			if d := distance(q, h.nodes[nb].vec); d < bestDist {
+				best, bestDist, changed = nb, d, true
+			}
+		}
+	}
+	return best
+}
+
+// searchLayer returns up to ef nodes closest to q on the given layer, nearest first
+func (h *HNSWIndex) searchLayer(q []float64, ep, ef, level int) []hnswCandidate {
+	visited := map[int]struct{}{ep: {}}
+	start := hnswCandidate{id: ep, dist: distance(q, h.nodes[ep].vec)}
+	candidates := &candidateMinHeap{start}
+	results := &candidateMaxHeap{start}
+
+	for candidates.Len() > 0 {
+		c := heap.Pop(candidates).(hnswCandidate)
+		if results.Len() >= ef && c.dist > (*results)[0].dist {
+			break
+		}
+		for _, nb := range h.nodes[c.id].links[level] {
+			if _, seen := visited[nb]; seen {
+				continue
+			}
+			visited[nb] = struct{}{}
+			d := distance(q, h.nodes[nb].vec)
+			if results.Len() < ef || d < (*results)[0].dist {
+				heap.Push(candidates, hnswCandidate{id: nb, dist: d})
+				heap.Push(results, hnswCandidate{id: nb, dist: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	out := []hnswCandidate(*results)
+	sort.Slice(out, func(i, j int) bool { return out[i].dist < out[j].dist })
+	return out
+}
+
+// distance is the cosine distance between two unit vectors
+func distance(a, b []float64) float64 {
+	var dot float64
+	for i := range a {
+		dot += a[i] * b[i]
+	}
+	return 1 - dot
+}
+
+func normalize(v []float64) []float64 {
+	var norm float64
+	for _, x := range v {
+		norm += x * x
+	}
+	out := make([]float64, len(v))
+	if norm == 0 {
+		return out
+	}
+	norm = math.Sqrt(norm)
+	for i, x := range v {
+		out[i] = x / norm
+	}
+	return out
+}
+
+type hnswCandidate struct {
+	id   int
+	dist float64
+}
+
+type candidateMinHeap []hnswCandidate
+
+func (h candidateMinHeap) Len() int           { return len(h) }
+func (h candidateMinHeap) Less(i, j int) bool { return h[i].dist < h[j].dist }
+func (h candidateMinHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *candidateMinHeap) Push(x any)        { *h = append(*h, x.(hnswCandidate)) }
+func (h *candidateMinHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+type candidateMaxHeap []hnswCandidate
+
+func (h candidateMaxHeap) Len() int           { return len(h) }
+func (h candidateMaxHeap) Less(i, j int) bool { return h[i].dist > h[j].dist }
+func (h candidateMaxHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *candidateMaxHeap) Push(x any)        { *h = append(*h, x.(hnswCandidate)) }
+func (h *candidateMaxHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
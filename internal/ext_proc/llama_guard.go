@@ -0,0 +1,128 @@
+package ext_proc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	otelCodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/kuadrant/inferno/internal/metrics"
+	"github.com/kuadrant/inferno/internal/tracing"
+)
+
+// llamaGuardCategories names the hazard categories of Llama Guard 3
+var llamaGuardCategories = map[string]string{
+	"S1":  "violent_crimes",
+	"S2":  "non_violent_crimes",
+	"S3":  "sex_related_crimes",
+	"S4":  "child_sexual_exploitation",
+	"S5":  "defamation",
+	"S6":  "specialized_advice",
+	"S7":  "privacy",
+	"S8":  "intellectual_property",
+	"S9":  "indiscriminate_weapons",
+	"S10": "hate",
+	"S11": "suicide_self_harm",
+	"S12": "sexual_content",
+	"S13": "elections",
+	"S14": "code_interpreter_abuse",
+}
+
+// LlamaGuard asks a Llama Guard model served behind an OpenAI compatible API, whose chat template
+// turns the conversation into its classification prompt. It answers safe, or unsafe followed by the
+// codes of the violated categories.
+type LlamaGuard struct {
+	name   string
+	client OpenAIChatCompleter
+	model  string
+}
+
+// NewLlamaGuard returns a backend calling model through client, meta-llama/Llama-Guard-3-8B by default
+func NewLlamaGuard(name string, client OpenAIChatCompleter, model string) *LlamaGuard {
+	if model == "" {
+		model = "meta-llama/Llama-Guard-3-8B"
+	}
+	return &LlamaGuard{name: name, client: client, model: model}
+}
+
+func (l *LlamaGuard) Name() string {
+	return l.name
+}
+
+// Check classifies the last turn of the conversation, the latest input or the response to it
+func (l *LlamaGuard) Check(ctx context.Context, in GuardInput) ([]string, error) {
+	if in.text() == "" {
+		return nil, nil
+	}
+	// the chat template expects the conversation to start with the user
+	msgs := in.dialogue()
+	if msgs[0].Role != openai.ChatMessageRoleUser {
+		msgs = append([]openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser}}, msgs...)
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "prompt_guard.llama_guard",
+		trace.WithAttributes(attribute.String("inferno.guardian.model", l.model)))
+	defer span.End()
+
+	start := time.Now()
+	resp, err := l.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model:       l.model,
+		Messages:    msgs,
+		Temperature: 0,
+		MaxTokens:   20,
+	})
+	metrics.GuardianDuration.WithLabelValues(metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+	if err == nil && len(resp.Choices) == 0 {
+		err = fmt.Errorf("no choices in Llama Guard response")
+	}
+	var categories []string
+	if err == nil {
+		categories, err = parseLlamaGuard(resp.Choices[0].Message.Content)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelCodes.Error, err.Error())
+		logger("prompt_guard").ErrorContext(ctx, "Llama Guard check failed", "backend", l.name, "error", err)
+		return nil, err
+	}
+	span.SetAttributes(attribute.StringSlice("inferno.guardian.categories", categories))
+	return categories, nil
+}
+
+// Ping lists the models rather than running the model
+func (l *LlamaGuard) Ping(ctx context.Context) error {
+	return pingModels(ctx, l.client)
+}
+
+// parseLlamaGuard reads a Llama Guard answer, "safe" or "unsafe" with the violated category codes on the
+// next line, such as "unsafe\nS1,S10"
+func parseLlamaGuard(answer string) ([]string, error) {
+	lines := strings.SplitN(strings.TrimSpace(answer), "\n", 2)
+	switch strings.ToLower(strings.TrimSpace(lines[0])) {
+	case "safe":
+		return nil, nil
+	case "unsafe":
+	default:
+		return nil, fmt.Errorf("unexpected Llama Guard answer %q", answer)
+	}
+
+	var categories []string
+	if len(lines) > 1 {
+		for _, code := range strings.FieldsFunc(lines[1], func(r rune) bool { return r == ',' || r == ' ' || r == '\n' }) {
+			code = strings.ToUpper(code)
+			if name, ok := llamaGuardCategories[code]; ok {
+				code = name
+			}
+			categories = append(categories, code)
+		}
+	}
+	if len(categories) == 0 {
+		categories = []string{"unsafe"}
+	}
+	return categories, nil
+}
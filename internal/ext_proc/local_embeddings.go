@@ -0,0 +1,93 @@
+package ext_proc
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// EmbeddingProviderLocal computes embeddings in-process, no model server required
+const EmbeddingProviderLocal = "local"
+
+const defaultLocalDimensions = 512
+
+// localMinThreshold is the lowest similarity threshold worth using with the local provider. Its lexical
+// vectors rate prompts sharing most of their words above 0.8 even when they ask different questions, such
+// as "capital of France" and "capital of Spain".
+const localMinThreshold = 0.9
+
+// feature weights, word n-grams carry the meaning while character trigrams tolerate
+// spelling variants and contractions such as "what's" vs "what is"
+const (
+	unigramWeight = 1.0
+	bigramWeight  = 1.0
+	trigramWeight = 0.5
+)
+
+// localEmbeddings is a hashed bag of n-grams model. Word unigrams, word bigrams and character
+// trigrams are hashed into a fixed number of signed buckets with sublinear term frequency and the
+// result is L2 normalized, so cosine similarity measures lexical overlap. It is much weaker than a
+// sentence transformer but deterministic, dependency free and fast enough to run on every request.
+type localEmbeddings struct {
+	dims int
+}
+
+func newLocalEmbeddings(dims int) *localEmbeddings {
+	if dims <= 0 {
+		dims = defaultLocalDimensions
+	}
+	return &localEmbeddings{dims: dims}
+}
+
+func (l *localEmbeddings) Embed(_ context.Context, texts []string) ([][]float64, error) {
+	embs := make([][]float64, len(texts))
+	for i, t := range texts {
+		embs[i] = l.embed(t)
+	}
+	return embs, nil
+}
+
+func (l *localEmbeddings) embed(text string) []float64 {
+	counts := map[string]float64{}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, w := range words {
+		counts["w:"+w] += unigramWeight
+		if i > 0 {
+			counts["b:"+words[i-1]+" "+w] += bigramWeight
+		}
+		padded := []rune(" " + w + " ")
+		for j := 0; j+3 <= len(padded); j++ {
+			counts["c:"+string(padded[j:j+3])] += trigramWeight
+		}
+	}
+
+	vec := make([]float64, l.dims)
+	for feature, tf := range counts {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		// the sign bit keeps colliding features from only ever adding up
+		sign := 1.0
+		if sum>>63 == 1 {
+			sign = -1
+		}
+		vec[sum%uint64(l.dims)] += sign * (1 + math.Log(tf))
+	}
+
+	var norm float64
+	for _, v := range vec {
+		norm += v * v
+	}
+	if norm == 0 {
+		return vec
+	}
+	norm = math.Sqrt(norm)
+	for i := range vec {
+		vec[i] /= norm
+	}
+	return vec
+}
@@ -0,0 +1,93 @@
+package ext_proc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	otelCodes "go.opentelemetry.io/otel/codes"
+
+	"github.com/kuadrant/inferno/internal/metrics"
+	"github.com/kuadrant/inferno/internal/tracing"
+)
+
+// ModerationClient defines the interface needed by OpenAIModeration
+type ModerationClient interface {
+	Moderations(ctx context.Context, req openai.ModerationRequest) (openai.ModerationResponse, error)
+}
+
+// OpenAIModeration checks texts with the OpenAI /v1/moderations endpoint, or a compatible one
+type OpenAIModeration struct {
+	name   string
+	client ModerationClient
+	model  string
+}
+
+// NewOpenAIModeration returns a backend calling the moderations endpoint through client, an empty model
+// uses the endpoint's default
+func NewOpenAIModeration(name string, client ModerationClient, model string) *OpenAIModeration {
+	return &OpenAIModeration{name: name, client: client, model: model}
+}
+
+func (m *OpenAIModeration) Name() string {
+	return m.name
+}
+
+// Check returns the moderation categories flagged on the text of the input's direction
+func (m *OpenAIModeration) Check(ctx context.Context, in GuardInput) ([]string, error) {
+	text := in.text()
+	if text == "" {
+		return nil, nil
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, "prompt_guard.moderation")
+	defer span.End()
+
+	start := time.Now()
+	resp, err := m.client.Moderations(ctx, openai.ModerationRequest{Input: text, Model: m.model})
+	metrics.GuardianDuration.WithLabelValues(metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+	if err == nil && len(resp.Results) == 0 {
+		err = errors.New("no results in moderation response")
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(otelCodes.Error, err.Error())
+		logger("prompt_guard").ErrorContext(ctx, "Moderation check failed", "backend", m.name, "error", err)
+		return nil, err
+	}
+
+	result := resp.Results[0]
+	if !result.Flagged {
+		return nil, nil
+	}
+	categories := flaggedCategories(result.Categories)
+	span.SetAttributes(attribute.StringSlice("inferno.guardian.categories", categories))
+	return categories, nil
+}
+
+// Ping lists the models, the moderation endpoint has no cheaper call
+func (m *OpenAIModeration) Ping(ctx context.Context) error {
+	return pingModels(ctx, m.client)
+}
+
+// flaggedCategories returns the names of the categories set, such as self-harm/intent
+func flaggedCategories(c openai.ResultCategories) []string {
+	b, _ := json.Marshal(c)
+	var set map[string]bool
+	_ = json.Unmarshal(b, &set)
+	var names []string
+	for name, flagged := range set {
+		if flagged {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return []string{"flagged"}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,568 @@
+package ext_proc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	filterPb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
+	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/kuadrant/inferno/internal/config"
+	"github.com/kuadrant/inferno/internal/ext_proc"
+	"github.com/kuadrant/inferno/internal/logging"
+	"github.com/kuadrant/inferno/internal/metrics"
+	"github.com/kuadrant/inferno/internal/testutil"
+)
+
+// embeddings served by the fake embedding server, unknown prompts map to an orthogonal vector
+var testEmbeddings = map[string][]float64{
+	"What is Kubernetes?": {1, 0, 0},
+	"What's Kubernetes?":  {0.9, 0.1, 0},
+}
+
+func newEmbeddingServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct{ Instances []string }
+		Expect(json.NewDecoder(r.Body).Decode(&req)).To(Succeed())
+		preds := make([][]float64, 0, len(req.Instances))
+		for _, in := range req.Instances {
+			emb, ok := testEmbeddings[in]
+			if !ok {
+				emb = []float64{0, 0, 1}
+			}
+			preds = append(preds, emb)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"predictions": preds})
+	}))
+}
+
+func headersRequest(headers map[string]string) *extProcPb.ProcessingRequest {
+	hm := &configPb.HeaderMap{}
+	for k, v := range headers {
+		hm.Headers = append(hm.Headers, &configPb.HeaderValue{Key: k, RawValue: []byte(v)})
+	}
+	return &extProcPb.ProcessingRequest{
+		Request: &extProcPb.ProcessingRequest_RequestHeaders{
+			RequestHeaders: &extProcPb.HttpHeaders{Headers: hm},
+		},
+	}
+}
+
+func requestBodyRequest(body string) *extProcPb.ProcessingRequest {
+	return &extProcPb.ProcessingRequest{
+		Request: &extProcPb.ProcessingRequest_RequestBody{
+			RequestBody: &extProcPb.HttpBody{Body: []byte(body), EndOfStream: true},
+		},
+	}
+}
+
+func responseHeadersRequest(headers map[string]string) *extProcPb.ProcessingRequest {
+	hm := &configPb.HeaderMap{}
+	for k, v := range headers {
+		hm.Headers = append(hm.Headers, &configPb.HeaderValue{Key: k, RawValue: []byte(v)})
+	}
+	return &extProcPb.ProcessingRequest{
+		Request: &extProcPb.ProcessingRequest_ResponseHeaders{
+			ResponseHeaders: &extProcPb.HttpHeaders{Headers: hm},
+		},
+	}
+}
+
+func responseBodyRequest(body string) *extProcPb.ProcessingRequest {
+	return responseChunkRequest(body, true)
+}
+
+func responseChunkRequest(body string, endOfStream bool) *extProcPb.ProcessingRequest {
+	return &extProcPb.ProcessingRequest{
+		Request: &extProcPb.ProcessingRequest_ResponseBody{
+			ResponseBody: &extProcPb.HttpBody{Body: []byte(body), EndOfStream: endOfStream},
+		},
+	}
+}
+
+func responseTrailersRequest() *extProcPb.ProcessingRequest {
+	return &extProcPb.ProcessingRequest{
+		Request: &extProcPb.ProcessingRequest_ResponseTrailers{
+			ResponseTrailers: &extProcPb.HttpTrailers{Trailers: &configPb.HeaderMap{}},
+		},
+	}
+}
+
+// processorStream runs one ext_proc stream against the processor
+type processorStream struct {
+	srv *testutil.MockExtProcServer
+}
+
+func startStream(p *ext_proc.Processor) *processorStream {
+	srv := testutil.NewMockExtProcServer(10)
+	go func() {
+		defer GinkgoRecover()
+		srv.Done <- p.Process(srv)
+	}()
+	DeferCleanup(srv.Close)
+	return &processorStream{srv: srv}
+}
+
+func (s *processorStream) send(req *extProcPb.ProcessingRequest) *extProcPb.ProcessingResponse {
+	s.srv.InjectRequest(req)
+	select {
+	case resp := <-s.srv.Responses:
+		return resp
+	case <-time.After(2 * time.Second):
+		Fail("timed out waiting for processor response")
+		return nil
+	}
+}
+
+// syncBuffer collects the log output of the processor goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+const (
+	kubernetesRequest  = `{"model": "gpt-4.1", "messages": [{"role": "user", "content": "What is Kubernetes?"}]}`
+	kubernetesRequest2 = `{"model": "gpt-4.1", "messages": [{"role": "user", "content": "What's Kubernetes?"}]}`
+	kubernetesResponse = `{"choices": [{"message": {"role": "assistant", "content": "A container orchestrator."}}], "usage": {"prompt_tokens": 5, "completion_tokens": 4, "total_tokens": 9}}`
+)
+
+// kubernetesStream is kubernetesResponse streamed with stream_options.include_usage, split into body chunks
+var kubernetesStream = []string{
+	"data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"model\":\"gpt-4.1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"A container \"}}]}\n\ndata: {\"id\":\"chatcmpl-1\",\"object\":\"chat.comp",
+	"letion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"orchestrator.\"},\"finish_reason\":\"stop\"}]}\n\n",
+	"data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":4,\"total_tokens\":9}}\n\ndata: [DONE]\n\n",
+}
+
+var _ = Describe("Processor", func() {
+	var p *ext_proc.Processor
+
+	BeforeEach(func() {
+		embeddings := newEmbeddingServer()
+		DeferCleanup(embeddings.Close)
+
+		GinkgoT().Setenv("EMBEDDING_MODEL_SERVER", embeddings.URL)
+		GinkgoT().Setenv("SEMANTIC_CACHE_STORE", "memory")
+		GinkgoT().Setenv("SEMANTIC_CACHE_SWEEP_INTERVAL", "0")
+		GinkgoT().Setenv("SIMILARITY_THRESHOLD", "0.9")
+		GinkgoT().Setenv("GUARDIAN_URL", "")
+		GinkgoT().Setenv("GUARDIAN_API_KEY", "")
+
+		p = ext_proc.NewProcessor()
+		DeferCleanup(p.Close)
+	})
+
+	// exchange sends a full request/response exchange and returns the request body and response headers responses,
+	// the latter is nil when the request was answered immediately
+	exchange := func(headers map[string]string, body string) (*extProcPb.ProcessingResponse, *extProcPb.ProcessingResponse) {
+		s := startStream(p)
+		s.send(headersRequest(headers))
+		resp := s.send(requestBodyRequest(body))
+		if resp.GetImmediateResponse() != nil {
+			return resp, nil
+		}
+		respHeaders := s.send(responseHeadersRequest(map[string]string{"content-type": "application/json"}))
+		s.send(responseBodyRequest(kubernetesResponse))
+		return resp, respHeaders
+	}
+
+	roundTrip := func(headers map[string]string, body string) *extProcPb.ProcessingResponse {
+		resp, _ := exchange(headers, body)
+		return resp
+	}
+
+	headerValues := func(opts []*configPb.HeaderValueOption) map[string]string {
+		out := map[string]string{}
+		for _, o := range opts {
+			out[o.Header.Key] = o.Header.Value
+		}
+		return out
+	}
+
+	defaultHeaders := func(extra map[string]string) map[string]string {
+		h := map[string]string{":path": "/v1/chat/completions", "authorization": "Bearer key-a"}
+		for k, v := range extra {
+			h[k] = v
+		}
+		return h
+	}
+
+	It("should serve a similar prompt from the cache", func() {
+		Expect(roundTrip(defaultHeaders(nil), kubernetesRequest).GetImmediateResponse()).To(BeNil())
+
+		resp := roundTrip(defaultHeaders(nil), kubernetesRequest2)
+		Expect(resp.GetImmediateResponse()).NotTo(BeNil())
+		Expect(string(resp.GetImmediateResponse().Body)).To(Equal(kubernetesResponse))
+	})
+
+	It("should not serve responses across API keys", func() {
+		roundTrip(defaultHeaders(nil), kubernetesRequest)
+
+		resp := roundTrip(defaultHeaders(map[string]string{"authorization": "Bearer key-b"}), kubernetesRequest)
+		Expect(resp.GetImmediateResponse()).To(BeNil())
+	})
+
+	It("should not cache responses without generated output", func() {
+		s := startStream(p)
+		s.send(headersRequest(defaultHeaders(nil)))
+		s.send(requestBodyRequest(kubernetesRequest))
+		s.send(responseHeadersRequest(map[string]string{"content-type": "application/json"}))
+		s.send(responseBodyRequest(`{"error": {"message": "model overloaded", "type": "server_error"}}`))
+
+		Expect(roundTrip(defaultHeaders(nil), kubernetesRequest).GetImmediateResponse()).To(BeNil())
+	})
+
+	Context("health checks", func() {
+		It("should check the configured dependencies", func() {
+			checks := p.HealthChecks()
+			Expect(checks).To(HaveKey(ext_proc.HealthEmbedding))
+			Expect(checks).To(HaveKey(ext_proc.HealthCacheStore))
+			Expect(checks).NotTo(HaveKey(ext_proc.HealthGuardian))
+
+			for _, check := range checks {
+				Expect(check(context.Background())).To(Succeed())
+			}
+		})
+	})
+
+	Context("cache diagnostics headers", func() {
+		It("should report a miss on the upstream response", func() {
+			_, respHeaders := exchange(defaultHeaders(nil), kubernetesRequest)
+			headers := headerValues(respHeaders.GetResponseHeaders().GetResponse().GetHeaderMutation().GetSetHeaders())
+			Expect(headers).To(HaveKeyWithValue("x-inferno-cache", "MISS"))
+			Expect(headers).NotTo(HaveKey("x-inferno-cache-entry"))
+		})
+
+		It("should report a hit with similarity, age and entry on the cached response", func() {
+			roundTrip(defaultHeaders(nil), kubernetesRequest)
+
+			resp := roundTrip(defaultHeaders(nil), kubernetesRequest2)
+			headers := headerValues(resp.GetImmediateResponse().GetHeaders().GetSetHeaders())
+			Expect(headers).To(HaveKeyWithValue("x-inferno-cache", "HIT"))
+			Expect(headers).To(HaveKeyWithValue("x-inferno-cache-similarity", "0.9939"))
+			Expect(headers).To(HaveKeyWithValue("x-inferno-cache-age", "0"))
+			Expect(headers["x-inferno-cache-entry"]).To(HaveLen(16))
+			Expect(headers).To(HaveKeyWithValue("x-kuadrant-openai-total-tokens", "9"))
+		})
+
+		It("should report a bypass when the client skips the cache", func() {
+			_, respHeaders := exchange(defaultHeaders(map[string]string{"cache-control": "no-cache"}), kubernetesRequest)
+			headers := headerValues(respHeaders.GetResponseHeaders().GetResponse().GetHeaderMutation().GetSetHeaders())
+			Expect(headers).To(HaveKeyWithValue("x-inferno-cache", "BYPASS"))
+		})
+	})
+
+	Context("metrics", func() {
+		It("should count cache lookups, similarity and tokens", func() {
+			hits := promtestutil.ToFloat64(metrics.CacheLookups.WithLabelValues("", "hit"))
+			misses := promtestutil.ToFloat64(metrics.CacheLookups.WithLabelValues("", "miss"))
+			prompt := promtestutil.ToFloat64(metrics.Tokens.WithLabelValues("gpt-4.1", "", "prompt"))
+			completion := promtestutil.ToFloat64(metrics.Tokens.WithLabelValues("gpt-4.1", "", "completion"))
+
+			exchange(defaultHeaders(nil), kubernetesRequest)
+			roundTrip(defaultHeaders(nil), kubernetesRequest2)
+
+			Expect(promtestutil.ToFloat64(metrics.CacheLookups.WithLabelValues("", "miss"))).To(Equal(misses + 1))
+			Expect(promtestutil.ToFloat64(metrics.CacheLookups.WithLabelValues("", "hit"))).To(Equal(hits + 1))
+			Expect(promtestutil.ToFloat64(metrics.Tokens.WithLabelValues("gpt-4.1", "", "prompt"))).To(Equal(prompt + 5))
+			Expect(promtestutil.ToFloat64(metrics.Tokens.WithLabelValues("gpt-4.1", "", "completion"))).To(Equal(completion + 4))
+			Expect(promtestutil.CollectAndCount(metrics.PhaseDuration)).To(BeNumerically(">=", 4))
+		})
+	})
+
+	Context("logging", func() {
+		It("should log with the request ID and without prompts when redacting", func() {
+			out := &syncBuffer{}
+			prev := slog.Default()
+			slog.SetDefault(slog.New(logging.NewHandler(out, "json")))
+			logging.Reload(config.LoggingConfig{Level: "debug", Redact: "hash"})
+			DeferCleanup(func() {
+				slog.SetDefault(prev)
+				logging.Reload(config.Default().Logging)
+			})
+
+			exchange(defaultHeaders(map[string]string{"x-request-id": "req-log"}), kubernetesRequest)
+			roundTrip(defaultHeaders(map[string]string{"x-request-id": "req-log-2"}), kubernetesRequest2)
+
+			// the mock server logs the raw messages, only look at the processor records
+			var records []string
+			for _, line := range strings.Split(out.String(), "\n") {
+				if strings.Contains(line, `"component":`) {
+					records = append(records, line)
+				}
+			}
+			Expect(records).To(ContainElement(ContainSubstring(`"request_id":"req-log"`)))
+			Expect(records).To(ContainElement(And(ContainSubstring(`"msg":"Semantic cache hit"`), ContainSubstring(`"request_id":"req-log-2"`))))
+			Expect(records).NotTo(ContainElement(ContainSubstring("Kubernetes")))
+			Expect(records).NotTo(ContainElement(ContainSubstring("container orchestrator")))
+		})
+	})
+
+	Context("tracing", func() {
+		var exporter *tracetest.InMemoryExporter
+
+		BeforeEach(func() {
+			exporter = tracetest.NewInMemoryExporter()
+			tp, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+			otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+			otel.SetTextMapPropagator(propagation.TraceContext{})
+			DeferCleanup(func() {
+				otel.SetTracerProvider(tp)
+				otel.SetTextMapPropagator(propagator)
+			})
+		})
+
+		spans := func() map[string]tracetest.SpanStub {
+			out := map[string]tracetest.SpanStub{}
+			for _, s := range exporter.GetSpans() {
+				out[s.Name] = s
+			}
+			return out
+		}
+
+		It("should trace the phases of a stream under the incoming trace", func() {
+			s := startStream(p)
+			s.send(headersRequest(defaultHeaders(map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"})))
+			s.send(requestBodyRequest(kubernetesRequest))
+			s.send(responseHeadersRequest(map[string]string{"content-type": "application/json"}))
+			s.send(responseBodyRequest(kubernetesResponse))
+			s.srv.Close()
+			Eventually(spans).Should(HaveKey("ext_proc"))
+
+			byName := spans()
+			stream := byName["ext_proc"]
+			Expect(stream.SpanContext.TraceID().String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+			Expect(stream.Parent.SpanID().String()).To(Equal("00f067aa0ba902b7"))
+			Expect(stream.Attributes).To(ContainElement(attribute.String("inferno.cache", "MISS")))
+			for _, phase := range []string{"request_headers", "request_body", "response_headers", "response_body"} {
+				Expect(byName["ext_proc "+phase].Parent.SpanID()).To(Equal(stream.SpanContext.SpanID()), phase)
+			}
+			body := byName["ext_proc request_body"].SpanContext.SpanID()
+			Expect(byName["semantic_cache.fetch_embedding"].Parent.SpanID()).To(Equal(body))
+			Expect(byName["semantic_cache.find_most_similar_prompt"].Parent.SpanID()).To(Equal(body))
+		})
+
+		It("should start a new trace without a traceparent", func() {
+			s := startStream(p)
+			s.send(headersRequest(defaultHeaders(nil)))
+			s.srv.Close()
+			Eventually(spans).Should(HaveKey("ext_proc"))
+			Expect(spans()["ext_proc"].Parent.IsValid()).To(BeFalse())
+		})
+	})
+
+	Context("request correlation", func() {
+		It("should release the request context when the stream ends", func() {
+			s := startStream(p)
+			s.send(headersRequest(defaultHeaders(map[string]string{"x-request-id": "req-1"})))
+			s.send(requestBodyRequest(kubernetesRequest))
+			Expect(p.ActiveRequests()).To(Equal(1))
+
+			s.srv.Close()
+			Eventually(p.ActiveRequests).Should(BeZero())
+		})
+
+		It("should cache each response under its own prompt when streams interleave", func() {
+			a, b := startStream(p), startStream(p)
+			a.send(headersRequest(defaultHeaders(map[string]string{"x-request-id": "req-a"})))
+			b.send(headersRequest(defaultHeaders(map[string]string{"x-request-id": "req-b"})))
+			a.send(requestBodyRequest(kubernetesRequest))
+			b.send(requestBodyRequest(`{"model": "gpt-4.1", "messages": [{"role": "user", "content": "Write a poem"}]}`))
+			Expect(p.ActiveRequests()).To(Equal(2))
+
+			b.send(responseHeadersRequest(map[string]string{"content-type": "application/json"}))
+			b.send(responseBodyRequest(`{"choices": [{"message": {"role": "assistant", "content": "Roses are red"}}]}`))
+			a.send(responseHeadersRequest(map[string]string{"content-type": "application/json"}))
+			a.send(responseBodyRequest(kubernetesResponse))
+
+			resp := roundTrip(defaultHeaders(nil), kubernetesRequest)
+			Expect(string(resp.GetImmediateResponse().GetBody())).To(Equal(kubernetesResponse))
+		})
+	})
+
+	Context("with an event stream response", func() {
+		stream := func(headers map[string]string, body string) *processorStream {
+			s := startStream(p)
+			s.send(headersRequest(headers))
+			Expect(s.send(requestBodyRequest(body)).GetImmediateResponse()).To(BeNil())
+			return s
+		}
+
+		It("should switch the response body to streamed mode", func() {
+			s := stream(defaultHeaders(nil), kubernetesRequest)
+			resp := s.send(responseHeadersRequest(map[string]string{"content-type": "text/event-stream"}))
+			Expect(resp.GetModeOverride().GetResponseBodyMode()).To(Equal(filterPb.ProcessingMode_STREAMED))
+			Expect(resp.GetModeOverride().GetResponseTrailerMode()).To(Equal(filterPb.ProcessingMode_SEND))
+		})
+
+		It("should keep buffering JSON responses", func() {
+			s := stream(defaultHeaders(nil), kubernetesRequest)
+			resp := s.send(responseHeadersRequest(map[string]string{"content-type": "application/json"}))
+			Expect(resp.GetModeOverride().GetResponseBodyMode()).To(Equal(filterPb.ProcessingMode_BUFFERED))
+		})
+
+		It("should forward chunks untouched and report usage in the trailers", func() {
+			s := stream(defaultHeaders(nil), kubernetesRequest)
+			s.send(responseHeadersRequest(map[string]string{"content-type": "text/event-stream"}))
+			for _, chunk := range kubernetesStream {
+				resp := s.send(responseChunkRequest(chunk, false))
+				Expect(resp.GetResponseBody().GetResponse().GetBodyMutation()).To(BeNil())
+			}
+			s.send(responseChunkRequest("", true))
+
+			resp := s.send(responseTrailersRequest())
+			trailers := headerValues(resp.GetResponseTrailers().GetHeaderMutation().GetSetHeaders())
+			Expect(trailers).To(HaveKeyWithValue("x-kuadrant-openai-prompt-tokens", "5"))
+			Expect(trailers).To(HaveKeyWithValue("x-kuadrant-openai-completion-tokens", "4"))
+			Expect(trailers).To(HaveKeyWithValue("x-kuadrant-openai-total-tokens", "9"))
+		})
+
+		It("should cache the streamed completion", func() {
+			s := stream(defaultHeaders(nil), kubernetesRequest)
+			s.send(responseHeadersRequest(map[string]string{"content-type": "text/event-stream"}))
+			for i, chunk := range kubernetesStream {
+				s.send(responseChunkRequest(chunk, i == len(kubernetesStream)-1))
+			}
+
+			resp := roundTrip(defaultHeaders(nil), kubernetesRequest2)
+			Expect(resp.GetImmediateResponse()).NotTo(BeNil())
+			var cached struct {
+				Choices []struct{ Message struct{ Content string } }
+			}
+			Expect(json.Unmarshal(resp.GetImmediateResponse().Body, &cached)).To(Succeed())
+			Expect(cached.Choices[0].Message.Content).To(Equal("A container orchestrator."))
+			headers := headerValues(resp.GetImmediateResponse().GetHeaders().GetSetHeaders())
+			Expect(headers).To(HaveKeyWithValue("x-kuadrant-openai-total-tokens", "9"))
+		})
+
+		It("should replay a cache hit as an event stream to streaming clients", func() {
+			roundTrip(defaultHeaders(nil), kubernetesRequest)
+
+			resp := roundTrip(defaultHeaders(nil), `{"model": "gpt-4.1", "stream": true, "messages": [{"role": "user", "content": "What's Kubernetes?"}]}`)
+			Expect(resp.GetImmediateResponse()).NotTo(BeNil())
+			headers := headerValues(resp.GetImmediateResponse().GetHeaders().GetSetHeaders())
+			Expect(headers).To(HaveKeyWithValue("content-type", "text/event-stream"))
+			Expect(headers).To(HaveKeyWithValue("x-inferno-cache", "HIT"))
+			body := string(resp.GetImmediateResponse().Body)
+			Expect(body).To(HavePrefix("data: "))
+			Expect(body).To(ContainSubstring(`"object":"chat.completion.chunk"`))
+			Expect(body).To(ContainSubstring(`"content":"A container orchestrator."`))
+			Expect(body).To(HaveSuffix("data: [DONE]\n\n"))
+		})
+
+		It("should not cache a stream that ended early", func() {
+			s := stream(defaultHeaders(nil), kubernetesRequest)
+			s.send(responseHeadersRequest(map[string]string{"content-type": "text/event-stream"}))
+			s.send(responseChunkRequest(kubernetesStream[0], true))
+
+			Expect(roundTrip(defaultHeaders(nil), kubernetesRequest).GetImmediateResponse()).To(BeNil())
+		})
+	})
+
+	Context("with cache control headers", func() {
+		It("should skip the lookup on no-cache", func() {
+			roundTrip(defaultHeaders(nil), kubernetesRequest)
+
+			resp := roundTrip(defaultHeaders(map[string]string{"cache-control": "no-cache"}), kubernetesRequest)
+			Expect(resp.GetImmediateResponse()).To(BeNil())
+		})
+
+		It("should not store the response on no-store", func() {
+			roundTrip(defaultHeaders(map[string]string{"cache-control": "no-store"}), kubernetesRequest)
+
+			resp := roundTrip(defaultHeaders(nil), kubernetesRequest)
+			Expect(resp.GetImmediateResponse()).To(BeNil())
+		})
+
+		It("should apply the similarity threshold override", func() {
+			roundTrip(defaultHeaders(nil), kubernetesRequest)
+
+			resp := roundTrip(defaultHeaders(map[string]string{"x-inferno-cache-threshold": "0.999"}), kubernetesRequest2)
+			Expect(resp.GetImmediateResponse()).To(BeNil())
+		})
+
+		It("should not let the threshold override lower the configured threshold", func() {
+			roundTrip(defaultHeaders(nil), kubernetesRequest)
+
+			resp := roundTrip(defaultHeaders(map[string]string{"x-inferno-cache-threshold": "0"}), `{"model": "gpt-4.1", "messages": [{"role": "user", "content": "What is the capital of France?"}]}`)
+			Expect(resp.GetImmediateResponse()).To(BeNil())
+		})
+
+		It("should strip the inferno headers before forwarding upstream", func() {
+			s := startStream(p)
+			resp := s.send(headersRequest(defaultHeaders(map[string]string{"x-inferno-cache-ttl": "60"})))
+			removed := resp.GetRequestHeaders().GetResponse().GetHeaderMutation().GetRemoveHeaders()
+			Expect(removed).To(ContainElements("x-inferno-cache-threshold", "x-inferno-cache-ttl"))
+		})
+	})
+
+	Context("with route metadata", func() {
+		// routeExchange runs an exchange whose request headers carry inferno dynamic metadata
+		routeExchange := func(settings map[string]interface{}, body string) (*extProcPb.ProcessingResponse, *extProcPb.ProcessingResponse) {
+			meta, err := structpb.NewStruct(settings)
+			Expect(err).NotTo(HaveOccurred())
+			headers := headersRequest(defaultHeaders(nil))
+			headers.MetadataContext = &configPb.Metadata{FilterMetadata: map[string]*structpb.Struct{"inferno": meta}}
+
+			s := startStream(p)
+			s.send(headers)
+			resp := s.send(requestBodyRequest(body))
+			if resp.GetImmediateResponse() != nil {
+				return resp, nil
+			}
+			s.send(responseHeadersRequest(map[string]string{"content-type": "application/json"}))
+			return resp, s.send(responseBodyRequest(kubernetesResponse))
+		}
+
+		It("should not cache on routes that disable the cache", func() {
+			disabled := map[string]interface{}{"semantic_cache": map[string]interface{}{"enabled": false}}
+			routeExchange(disabled, kubernetesRequest)
+
+			resp, _ := routeExchange(disabled, kubernetesRequest)
+			Expect(resp.GetImmediateResponse()).To(BeNil())
+			Expect(roundTrip(defaultHeaders(nil), kubernetesRequest).GetImmediateResponse()).To(BeNil())
+		})
+
+		It("should apply the route similarity threshold", func() {
+			roundTrip(defaultHeaders(nil), kubernetesRequest)
+
+			resp, _ := routeExchange(map[string]interface{}{"semantic_cache": map[string]interface{}{"similarity_threshold": 0.999}}, kubernetesRequest2)
+			Expect(resp.GetImmediateResponse()).To(BeNil())
+		})
+
+		It("should not add token usage headers on routes that disable them", func() {
+			_, body := routeExchange(map[string]interface{}{"token_metrics": map[string]interface{}{"enabled": false}}, kubernetesRequest)
+			for _, h := range body.GetResponseBody().GetResponse().GetHeaderMutation().GetSetHeaders() {
+				Expect(h.Header.Key).NotTo(HavePrefix("x-kuadrant-openai-"))
+			}
+		})
+	})
+})
@@ -2,22 +2,19 @@ package ext_proc
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"log"
-	"os"
+	"log/slog"
 	"strings"
+	"sync/atomic"
 	"time"
 
-	corePb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
-	filterPb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
 	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
-	statusPb "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	typeV3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 	"github.com/sashabaranov/go-openai"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+
+	"github.com/kuadrant/inferno/internal/config"
+	"github.com/kuadrant/inferno/internal/logging"
 )
 
 //  prompt parsing helpers
@@ -35,16 +32,8 @@ func extractPromptFromCompletions(bodyMap map[string]interface{}) (string, bool)
 // ref: https://platform.openai.com/docs/api-reference/chat/create
 func extractPromptFromChat(bodyMap map[string]interface{}) (string, bool) {
 	if msgs, ok := bodyMap["messages"].([]interface{}); ok {
-		var parts []string
-		for _, m := range msgs {
-			if mm, ok2 := m.(map[string]interface{}); ok2 {
-				if c, ok3 := mm["content"].(string); ok3 && c != "" {
-					parts = append(parts, c)
-				}
-			}
-		}
-		if len(parts) > 0 {
-			return strings.Join(parts, "\n"), true
+		if p := conversationText(chatTurns(msgs)); p != "" {
+			return p, true
 		}
 	}
 	return "", false
@@ -53,8 +42,18 @@ func extractPromptFromChat(bodyMap map[string]interface{}) (string, bool) {
 // `/v1/responses`
 // ref: https://platform.openai.com/docs/api-reference/responses
 func extractPromptFromResponses(bodyMap map[string]interface{}) (string, bool) {
-	if inp, ok := bodyMap["input"].(string); ok && inp != "" {
-		return inp, true
+	switch inp := bodyMap["input"].(type) {
+	case string:
+		return inp, inp != ""
+	case []interface{}:
+		var turns []Turn
+		for _, t := range responsesTurns(bodyMap) {
+			if t.Role != openai.ChatMessageRoleSystem {
+				turns = append(turns, t)
+			}
+		}
+		p := conversationText(turns)
+		return p, p != ""
 	}
 	return "", false
 }
@@ -72,287 +71,270 @@ func extractPrompt(bodyMap map[string]interface{}) (string, error) {
 	return "", fmt.Errorf("no prompt found in request body")
 }
 
-// OpenAIChatCompleter defines the interface needed by PromptGuard
+// OpenAIChatCompleter defines the interface needed by the chat model guard backends
 type OpenAIChatCompleter interface {
 	CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
 }
 
 type PromptGuard struct {
-	apiKey      string
-	baseURL     string
-	fullBaseURL string
-	modelName   string
-	riskyToken  string
-	client      OpenAIChatCompleter
+	BaseFilter
+	// backend judges the texts, nil when no guard is configured
+	backend GuardBackend
+	// settings is swapped on reload, requests read which checks run from it
+	settings atomic.Pointer[config.PromptGuardConfig]
+	// fallback is the local detector of the degrade policy, rebuilt on reload
+	fallback atomic.Pointer[RuleGuard]
 }
 
+// NewPromptGuard builds a guard configured from environment variables, client replaces the guardian
+// client when set
 func NewPromptGuard(client OpenAIChatCompleter) *PromptGuard {
-	apiKey := os.Getenv("GUARDIAN_API_KEY")
-	baseURL := os.Getenv("GUARDIAN_URL")
-	fullBaseURL := baseURL + "/openai/v1"
-	modelName := "granite-guardian"
-	riskyToken := "Yes"
-
-	if apiKey == "" {
-		log.Println("[PromptGuard] Warning: GUARDIAN_API_KEY env var is not set")
-	}
-	if baseURL == "" {
-		log.Println("[PromptGuard] Warning: GUARDIAN_URL env var is not set")
-	}
-
-	if client == nil && apiKey != "" && baseURL != "" {
-		cfg := openai.DefaultConfig(apiKey)
-		cfg.BaseURL = fullBaseURL
-		c := openai.NewClientWithConfig(cfg)
-		client = c
-		log.Printf("[PromptGuard] Initialized with base URL: %s", fullBaseURL)
-	}
+	return NewPromptGuardWithConfig(configFromEnv().PromptGuard, client)
+}
 
-	return &PromptGuard{
-		apiKey:      apiKey,
-		baseURL:     baseURL,
-		fullBaseURL: fullBaseURL,
-		modelName:   modelName,
-		riskyToken:  riskyToken,
-		client:      client,
-	}
+// NewPromptGuardWithConfig builds the configured backends, voting when there are several. Without
+// backends it calls Granite Guardian at the guardian URL, or through client when set.
+func NewPromptGuardWithConfig(cfg config.PromptGuardConfig, client OpenAIChatCompleter) *PromptGuard {
+	pg := &PromptGuard{backend: newPromptGuardBackend(cfg, client)}
+	pg.Reload(cfg)
+	return pg
 }
 
-func (pg *PromptGuard) CheckRisk(ctx context.Context, userQuery string) bool {
-	if pg.client == nil {
-		log.Println("[PromptGuard] Client not initialized, skipping risk check")
-		return false
+func newPromptGuardBackend(cfg config.PromptGuardConfig, client OpenAIChatCompleter) GuardBackend {
+	log := logger("prompt_guard")
+	if len(cfg.Backends) == 0 {
+		if client == nil && cfg.APIKey != "" && cfg.URL != "" {
+			fullBaseURL := cfg.URL + "/openai/v1"
+			oc := openai.DefaultConfig(cfg.APIKey)
+			oc.BaseURL = fullBaseURL
+			client = openai.NewClientWithConfig(oc)
+			log.Info("Initialized guardian client", "url", fullBaseURL)
+		}
+		if client == nil {
+			log.Warn("Guardian URL or API key is not set, no guard backend configured")
+			return nil
+		}
+		return NewGraniteGuardian("granite-guardian", client, "")
 	}
 
-	log.Printf("👮‍♀️ [Guardian] Checking risk on: '%s'\n", userQuery)
-	log.Printf("→ Sending to: %s/chat/completions with model '%s'\n", pg.fullBaseURL, pg.modelName)
-
-	resp, err := pg.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model: pg.modelName,
-		Messages: []openai.ChatCompletionMessage{
-			{
-				Role:    openai.ChatMessageRoleUser,
-				Content: userQuery,
-			},
-		},
-		Temperature: 0.01,
-		MaxTokens:   50,
-	})
-	if err != nil {
-		if status.Code(err) == codes.Canceled {
-			log.Println("[PromptGuard] Risk check canceled by context, returning safe")
-			return false
+	var backends []GuardBackend
+	for _, bc := range cfg.Backends {
+		b, err := newGuardBackend(bc)
+		if err != nil {
+			log.Error("Skipping guard backend", "type", bc.Type, "name", bc.Name, "error", err)
+			continue
 		}
-		log.Printf("[PromptGuard] Risk model call failed: %v", err)
-		return false
+		log.Info("Initialized guard backend", "type", bc.Type, "name", b.Name(), "url", bc.URL)
+		backends = append(backends, b)
 	}
-
-	if len(resp.Choices) == 0 {
-		log.Println("[PromptGuard] No choices in response")
-		return false
+	switch len(backends) {
+	case 0:
+		return nil
+	case 1:
+		return backends[0]
 	}
-	result := strings.TrimSpace(resp.Choices[0].Message.Content)
-	log.Printf("🛡️ Risk Model Response: %s\n", result)
-
-	return strings.EqualFold(result, pg.riskyToken)
+	return NewVotingGuard(cfg.Voting, backends...)
 }
 
-func (pg *PromptGuard) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
-	log.Println("[PromptGuard] Starting processing loop")
-	for {
-		req, err := srv.Recv()
-		if err == io.EOF {
-			log.Println("[PromptGuard] Received EOF, terminating processing loop")
-			return nil
-		}
-		if err != nil {
-			if status.Code(err) == codes.Canceled || errors.Is(err, context.Canceled) {
-				log.Println("[PromptGuard] Stream cancelled, finishing up")
-				return nil
-			}
-			log.Printf("[PromptGuard] Error receiving request: %v", err)
-			return err
-		}
-
-		log.Printf("[PromptGuard] Received request: %+v", req)
-
-		var resp *extProcPb.ProcessingResponse
-
-		switch r := req.Request.(type) {
-		case *extProcPb.ProcessingRequest_RequestHeaders:
-			log.Println("[PromptGuard] Processing RequestHeaders")
-			// pass through headers untouched
-			resp = &extProcPb.ProcessingResponse{
-				Response: &extProcPb.ProcessingResponse_RequestHeaders{
-					RequestHeaders: &extProcPb.HeadersResponse{},
-				},
-			}
-			log.Println("[PromptGuard] RequestHeaders processed, passing through response unchanged")
-
-		case *extProcPb.ProcessingRequest_RequestBody:
-			log.Println("[PromptGuard] Processing RequestBody")
+// Reload applies the settings that can change while running, which checks run and the failure
+// policies. The backends are only built at startup.
+func (pg *PromptGuard) Reload(cfg config.PromptGuardConfig) {
+	pg.fallback.Store(newFallbackGuard(cfg.FallbackPatterns))
+	pg.settings.Store(&cfg)
+}
 
-			bodyStr := string(r.RequestBody.Body)
-			log.Printf("[PromptGuard] Request body: %s", bodyStr)
+// CheckRisk reports whether the guard flags the prompt for any of the configured prompt risks, prompts
+// it gives no verdict on are safe
+func (pg *PromptGuard) CheckRisk(ctx context.Context, userQuery string) bool {
+	risks, _ := pg.CheckRisks(ctx, pg.settings.Load().Risks.Prompt, userQuery)
+	return len(risks) > 0
+}
 
-			var bodyMap map[string]interface{}
-			if err := json.Unmarshal(r.RequestBody.Body, &bodyMap); err != nil {
-				log.Printf("[PromptGuard] Failed to parse request body: %v", err)
-				return status.Errorf(codes.InvalidArgument, "invalid request body: %v", err)
-			}
+// CheckRisks checks the prompt and returns the risks the guard flagged. The error reports the checks
+// that gave no verdict, and is only returned when no risk was flagged.
+func (pg *PromptGuard) CheckRisks(ctx context.Context, risks []string, userQuery string) ([]string, error) {
+	if pg.backend == nil {
+		return nil, errGuardNotConfigured
+	}
+	return pg.backend.Check(ctx, GuardInput{Direction: "prompt", Prompt: userQuery, Risks: risks})
+}
 
-			prompt, err := extractPrompt(bodyMap)
-			if err != nil {
-				log.Printf("[PromptGuard] %v", err)
-				return status.Errorf(codes.InvalidArgument, "%v", err)
-			}
-			log.Printf("[PromptGuard] Extracted prompt: %s", prompt)
+var errGuardNotConfigured = errors.New("no guard backend configured")
 
-			if os.Getenv("DISABLE_PROMPT_RISK_CHECK") == "yes" {
-				log.Println("[PromptGuard] Prompt risk check disabled via env var, allowing request")
-				resp = &extProcPb.ProcessingResponse{
-					Response: &extProcPb.ProcessingResponse_RequestBody{
-						RequestBody: &extProcPb.BodyResponse{},
-					},
-				}
-			} else {
-				// use independent timeout so we don't get canceled by srv.Context
-				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-				defer cancel()
-				if pg.CheckRisk(ctx, prompt) {
-					log.Println("[PromptGuard] Risky prompt detected, returning 403")
+// decide checks the route's risks on the conversation of a direction and records the decision, applying
+// the failure policy when the guard gives no verdict
+func (pg *PromptGuard) decide(rc *RequestContext, in GuardInput) guardDecision {
+	// use independent timeout so we don't get canceled by srv.Context
+	ctx, cancel := context.WithTimeout(rc.Context(), 2*time.Second)
+	defer cancel()
 
-					resp = &extProcPb.ProcessingResponse{
-						Response: &extProcPb.ProcessingResponse_ImmediateResponse{
-							ImmediateResponse: &extProcPb.ImmediateResponse{
-								Status: &statusPb.HttpStatus{
-									Code: statusPb.StatusCode_Forbidden,
-								},
-								Body: []byte(`{"error":"Prompt blocked by content policy"}`),
-								Headers: &extProcPb.HeaderMutation{
-									SetHeaders: []*corePb.HeaderValueOption{
-										{
-											Header: &corePb.HeaderValue{
-												Key:   "Content-Type",
-												Value: "application/json",
-											},
-										},
-									},
-								},
-							},
-						},
-					}
-				} else {
-					log.Println("[PromptGuard] Prompt safe, allowing request")
-					resp = &extProcPb.ProcessingResponse{
-						Response: &extProcPb.ProcessingResponse_RequestBody{
-							RequestBody: &extProcPb.BodyResponse{},
-						},
-					}
-				}
-			}
+	settings := pg.settings.Load()
+	in.Risks = rc.route.risks(in.Direction, settings)
+	flagged, err := []string(nil), errGuardNotConfigured
+	if pg.backend != nil {
+		flagged, err = checkBackend(ctx, pg.backend, in)
+	}
 
-		case *extProcPb.ProcessingRequest_ResponseHeaders:
-			log.Println("[PromptGuard] Processing ResponseHeaders, instructing Envoy to buffer response body")
-			resp = &extProcPb.ProcessingResponse{
-				Response: &extProcPb.ProcessingResponse_ResponseHeaders{
-					ResponseHeaders: &extProcPb.HeadersResponse{},
-				},
-				ModeOverride: &filterPb.ProcessingMode{
-					ResponseHeaderMode: filterPb.ProcessingMode_SKIP,
-					ResponseBodyMode:   filterPb.ProcessingMode_BUFFERED,
-				},
+	decision := guardAllow
+	if err == nil {
+		if len(flagged) > 0 {
+			decision = guardBlock
+			rc.guard.risks = flagged
+		}
+	} else {
+		policy := failurePolicy(settings, in.Direction)
+		switch policy {
+		case "closed":
+			decision = guardFailClosed
+		case "degrade":
+			decision = guardDegradedAllow
+			if len(pg.fallback.Load().match(in.text())) > 0 {
+				decision = guardDegradedBlock
 			}
-			log.Println("[PromptGuard] ResponseHeaders processed, buffering response body")
-
-		case *extProcPb.ProcessingRequest_ResponseBody:
-			log.Println("[PromptGuard] Processing ResponseBody")
-			rb := r.ResponseBody
-			log.Printf("[PromptGuard] ResponseBody received, EndOfStream: %v", rb.EndOfStream)
+		default:
+			decision = guardFailOpen
+		}
+		// an unconfigured guard fails every check, which is not worth a warning each time
+		level := slog.LevelWarn
+		if pg.backend == nil {
+			level = slog.LevelDebug
+		}
+		logger("prompt_guard").Log(rc.Context(), level, "No risk verdict, applying failure policy",
+			"direction", in.Direction, "policy", policy, "decision", decision, "error", err)
+	}
+	rc.guard.set(rc, in.Direction, decision)
+	return decision
+}
 
-			if !rb.EndOfStream {
-				log.Println("[PromptGuard] ResponseBody not complete, continuing to buffer")
-				break
-			}
+func (pg *PromptGuard) Name() string {
+	return "prompt-guard"
+}
 
-			bodyStr := string(rb.Body)
-			log.Printf("[PromptGuard] Full response body: %s", bodyStr)
+// OnRequestBody rejects risky prompts with a 403, and with a 503 when the guardian gives no verdict and
+// the prompt policy is closed
+func (pg *PromptGuard) OnRequestBody(rc *RequestContext, body []byte) PhaseResult {
+	if rc.prompt == "" {
+		return PhaseResult{}
+	}
+	if !rc.route.promptCheck(pg.settings.Load().CheckPrompt) {
+		logger("prompt_guard").DebugContext(rc.Context(), "Prompt risk check disabled, allowing request")
+		return PhaseResult{}
+	}
 
-			var respData map[string]interface{}
-			if err := json.Unmarshal(rb.Body, &respData); err != nil {
-				log.Printf("[PromptGuard] Failed to parse response body: %v", err)
-				return status.Errorf(codes.InvalidArgument, "invalid response body: %v", err)
-			}
+	in := requestGuardInput(rc)
+	in.Direction = "prompt"
+	decision := pg.decide(rc, in)
+	switch {
+	case decision.blocks():
+		logger("prompt_guard").InfoContext(rc.Context(), "Risky prompt detected, returning 403", "prompt", logging.Text(rc.prompt), "decision", decision, "risks", rc.guard.risks)
+		return pg.reject(rc, "prompt", createForbiddenResponse("Prompt blocked by content policy"))
+	case decision == guardFailClosed:
+		return pg.reject(rc, "prompt", createErrorResponse(typeV3.StatusCode_ServiceUnavailable, "Prompt risk check unavailable"))
+	}
+	logger("prompt_guard").DebugContext(rc.Context(), "Prompt allowed", "decision", decision)
+	return PhaseResult{}
+}
 
-			var generated string
-			choices, ok := respData["choices"].([]interface{})
-			if ok && len(choices) > 0 {
-				first, _ := choices[0].(map[string]interface{})
-				generated, _ = first["text"].(string)
-			}
-			log.Printf("[PromptGuard] Extracted response text: %s", generated)
+// OnResponseHeaders reports the decision of the prompt check
+func (pg *PromptGuard) OnResponseHeaders(rc *RequestContext, headers map[string]string) PhaseResult {
+	return PhaseResult{SetHeaders: rc.guard.header("prompt")}
+}
 
-			if os.Getenv("DISABLE_RESPONSE_RISK_CHECK") == "yes" {
-				log.Println("[PromptGuard] Response risk check disabled via env var, allowing response")
-				resp = &extProcPb.ProcessingResponse{
-					Response: &extProcPb.ProcessingResponse_ResponseBody{
-						ResponseBody: &extProcPb.BodyResponse{},
-					},
-				}
-			} else {
-				// use independent timeout so we don't get canceled by srv.Context
-				ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-				defer cancel()
-				if pg.CheckRisk(ctx, generated) {
-					log.Println("[PromptGuard] Risky LLM output detected, blocking response")
-					resp = &extProcPb.ProcessingResponse{
-						Response: &extProcPb.ProcessingResponse_ImmediateResponse{
-							ImmediateResponse: &extProcPb.ImmediateResponse{
-								Status: &statusPb.HttpStatus{
-									Code: statusPb.StatusCode_Forbidden,
-								},
-								Body: []byte(`{"error":"LLM output blocked by safety filter"}`),
-								Headers: &extProcPb.HeaderMutation{
-									SetHeaders: []*corePb.HeaderValueOption{
-										{
-											Header: &corePb.HeaderValue{
-												Key:   "Content-Type",
-												Value: "application/json",
-											},
-										},
-									},
-								},
-							},
-						},
-					}
-				} else {
-					log.Println("[PromptGuard] LLM output safe, allowing response")
-					resp = &extProcPb.ProcessingResponse{
-						Response: &extProcPb.ProcessingResponse_ResponseBody{
-							ResponseBody: &extProcPb.BodyResponse{},
-						},
-					}
-				}
+// OnResponseBody checks the generated text once the response is complete. A risky buffered response is
+// replaced with a 403. An event stream is also checked every StreamCheckInterval bytes of output, and a risky
+// one is cut with an error event in place of the current chunk, the chunks before it have already been
+// forwarded. When the guardian gives no verdict and the response policy is closed, the same happens with a 503.
+func (pg *PromptGuard) OnResponseBody(rc *RequestContext, body []byte, endOfStream bool) PhaseResult {
+	if rc.guard.cut {
+		return PhaseResult{Body: []byte{}, Stop: true}
+	}
+	settings := pg.settings.Load()
+	if !rc.route.responseCheck(settings.CheckResponse) {
+		if endOfStream {
+			logger("prompt_guard").DebugContext(rc.Context(), "Response risk check disabled, allowing response")
+		}
+		return PhaseResult{}
+	}
+	if rc.stream != nil {
+		size := rc.stream.size()
+		due := settings.StreamCheckInterval > 0 && size-rc.guard.checked >= settings.StreamCheckInterval
+		// the output checked last is judged again at the end only if it grew
+		if !due && !(endOfStream && size > rc.guard.checked) {
+			if endOfStream {
+				rc.guard.finish(rc)
 			}
-
-		default:
-			log.Printf("[PromptGuard] Received unrecognized request type: %+v", r)
-			resp = &extProcPb.ProcessingResponse{}
+			return PhaseResult{}
 		}
+		rc.guard.checked = size
+	} else if !endOfStream {
+		return PhaseResult{}
+	}
 
-		// Handle cases like ResponseBody EndOfStream=false
-		if resp == nil {
-			log.Println("[PromptGuard] Skipping Send for nil response")
-			continue
+	var out responseOutput
+	if rc.stream != nil {
+		out = rc.stream.output()
+	} else {
+		out = extractResponseOutput(rc.response)
+	}
+	if out.empty() {
+		return PhaseResult{}
+	}
+	in := requestGuardInput(rc)
+	in.Direction = "response"
+	in.Response, in.ToolCalls = out.Text, out.ToolCalls
+	generated := in.text()
+	logger("prompt_guard").DebugContext(rc.Context(), "Extracted response text", "completion", logging.Text(generated))
+
+	decision := pg.decide(rc, in)
+	if rc.stream != nil && (endOfStream || decision.blocks() || decision == guardFailClosed) {
+		rc.guard.finish(rc)
+	}
+	switch {
+	case decision.blocks():
+		logger("prompt_guard").InfoContext(rc.Context(), "Risky LLM output detected, blocking response", "completion", logging.Text(generated), "decision", decision, "risks", rc.guard.risks)
+		if rc.stream != nil {
+			rc.guard.cut = true
+			return PhaseResult{Body: streamBlockedEvent("LLM output blocked by safety filter"), Stop: true}
 		}
-
-		if err := srv.Send(resp); err != nil {
-			log.Printf("[PromptGuard] Error sending response: %v", err)
-			if status.Code(err) == codes.Canceled || errors.Is(err, context.Canceled) {
-				log.Println("[PromptGuard] Stream canceled, exiting cleanly")
-				return nil
-			}
-			return status.Errorf(codes.Unknown, "cannot send stream response: %v", err)
+		return pg.reject(rc, "response", createForbiddenResponse("LLM output blocked by safety filter"))
+	case decision == guardFailClosed:
+		if rc.stream != nil {
+			rc.guard.cut = true
+			return PhaseResult{Body: streamBlockedEvent("LLM output risk check unavailable"), Stop: true}
 		}
+		return pg.reject(rc, "response", createErrorResponse(typeV3.StatusCode_ServiceUnavailable, "LLM output risk check unavailable"))
+	}
+	logger("prompt_guard").DebugContext(rc.Context(), "LLM output allowed", "decision", decision)
+	if rc.stream != nil {
+		return PhaseResult{}
 	}
+	// the headers of a buffered response are held until its body is processed
+	return PhaseResult{SetHeaders: rc.guard.header("response")}
+}
+
+// OnResponseTrailers reports the decision of the response check of an event stream, whose headers were
+// sent before it could be checked
+func (pg *PromptGuard) OnResponseTrailers(rc *RequestContext) PhaseResult {
+	return PhaseResult{SetHeaders: rc.guard.header("response")}
+}
+
+// reject answers the client with resp, carrying the decisions made so far
+func (pg *PromptGuard) reject(rc *RequestContext, direction string, resp *extProcPb.ProcessingResponse) PhaseResult {
+	immediate := resp.GetImmediateResponse()
+	immediate.Headers.SetHeaders = append(immediate.Headers.SetHeaders, rc.guard.header("prompt")...)
+	if direction == "response" {
+		immediate.Headers.SetHeaders = append(immediate.Headers.SetHeaders, rc.guard.header("response")...)
+	}
+	if len(rc.guard.risks) > 0 {
+		immediate.Headers.SetHeaders = append(immediate.Headers.SetHeaders, cacheHeader(guardRisksHeader, strings.Join(rc.guard.risks, ",")))
+	}
+	return PhaseResult{Immediate: immediate}
+}
+
+// Process runs the prompt guard as the only filter of a chain. Unlike the processor it rejects
+// bodies that are not JSON or carry no prompt, since it has nothing to check on them.
+func (pg *PromptGuard) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
+	chain := NewChain(pg)
+	chain.strict = true
+	return chain.Process(srv)
 }
@@ -0,0 +1,294 @@
+package ext_proc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisCacheStore shares entries between replicas through Redis hashes indexed by a RediSearch vector field.
+// When the server has no search module it falls back to scanning the hashes, which is only meant for small caches and tests.
+// ref: https://redis.io/docs/latest/develop/interact/search-and-query/advanced-concepts/vectors/
+type RedisCacheStore struct {
+	client    *redis.Client
+	prefix    string
+	indexName string
+	ttl       time.Duration
+
+	mu       sync.Mutex
+	indexDim int
+	scanOnly bool
+	// scanCapped warns once that scan lookups stopped at scanLimit keys
+	scanCapped sync.Once
+}
+
+// scanLimit bounds the keys a scan lookup reads, each costs a round trip to Redis
+const scanLimit = 1000
+
+type RedisCacheStoreConfig struct {
+	URL       string
+	Prefix    string
+	IndexName string
+	TTL       time.Duration
+}
+
+func NewRedisCacheStore(cfg RedisCacheStoreConfig) (*RedisCacheStore, error) {
+	opts, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis url: %w", err)
+	}
+	// search replies are parsed in their RESP2 array form
+	opts.Protocol = 2
+	if cfg.Prefix == "" {
+		cfg.Prefix = "inferno:cache:"
+	}
+	if cfg.IndexName == "" {
+		cfg.IndexName = "inferno-semantic-cache"
+	}
+	return &RedisCacheStore{
+		client:    redis.NewClient(opts),
+		prefix:    cfg.Prefix,
+		indexName: cfg.IndexName,
+		ttl:       cfg.TTL,
+	}, nil
+}
+
+func (s *RedisCacheStore) Store(ctx context.Context, e *CacheEntry, ttl time.Duration) error {
+	if ttl <= 0 {
+		ttl = s.ttl
+	}
+	if err := s.ensureIndex(ctx, len(e.Embedding)); err != nil {
+		return err
+	}
+	key := s.prefix + e.ID
+	pipe := s.client.TxPipeline()
+	pipe.HSet(ctx, key,
+		"scope", scopeTag(e.Scope),
+		"prompt", e.Prompt,
+		"response", e.Response,
+		"embedding", encodeVector(e.Embedding),
+		"create_time", e.CreateTime.UnixNano(),
+	)
+	if ttl > 0 {
+		pipe.PExpire(ctx, key, ttl)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("redis store failed: %w", err)
+	}
+	return nil
+}
+
+func (s *RedisCacheStore) Lookup(ctx context.Context, scope string, vec []float64) (*CacheEntry, float64, error) {
+	if len(vec) == 0 {
+		return nil, 0, nil
+	}
+	if err := s.ensureIndex(ctx, len(vec)); err != nil {
+		return nil, 0, err
+	}
+	s.mu.Lock()
+	scanOnly := s.scanOnly
+	s.mu.Unlock()
+	if scanOnly {
+		return s.scanLookup(ctx, scope, vec)
+	}
+	return s.searchLookup(ctx, scope, vec)
+}
+
+func (s *RedisCacheStore) Ping(ctx context.Context) error {
+	return s.client.Ping(ctx).Err()
+}
+
+func (s *RedisCacheStore) Close() error {
+	return s.client.Close()
+}
+
+// ensureIndex creates the search index once the embedding dimension is known, and rejects embeddings of
+// another dimension afterwards
+func (s *RedisCacheStore) ensureIndex(ctx context.Context, dim int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.scanOnly || dim == 0 {
+		return nil
+	}
+	if s.indexDim != 0 {
+		if dim != s.indexDim {
+			return s.dimensionMismatch(s.indexDim, dim)
+		}
+		return nil
+	}
+	err := s.client.Do(ctx, "FT.CREATE", s.indexName,
+		"ON", "HASH", "PREFIX", "1", s.prefix,
+		"SCHEMA", "scope", "TAG",
+		"embedding", "VECTOR", "HNSW", "6",
+		"TYPE", "FLOAT32", "DIM", dim, "DISTANCE_METRIC", "COSINE",
+	).Err()
+	switch {
+	case err == nil:
+		logger("redis_store").InfoContext(ctx, "Created index", "index", s.indexName, "dimensions", dim)
+	case strings.Contains(strings.ToLower(err.Error()), "index already exists"):
+		existing, err := s.indexDimension(ctx)
+		if err != nil {
+			return err
+		}
+		if existing != 0 && existing != dim {
+			return s.dimensionMismatch(existing, dim)
+		}
+	case strings.Contains(strings.ToLower(err.Error()), "unknown command"):
+		logger("redis_store").WarnContext(ctx, "Search module unavailable, falling back to key scans", "error", err)
+		s.scanOnly = true
+		return nil
+	default:
+		return fmt.Errorf("redis index creation failed: %w", err)
+	}
+	s.indexDim = dim
+	return nil
+}
+
+// dimensionMismatch reports embeddings that do not fit the index and how to recover
+func (s *RedisCacheStore) dimensionMismatch(indexDim, dim int) error {
+	return fmt.Errorf("redis index %s holds %d dimensional vectors but embeddings have %d, drop it with FT.DROPINDEX or set a different index name", s.indexName, indexDim, dim)
+}
+
+// indexDimension reads the DIM of the embedding field from FT.INFO, or 0 if the reply does not list it
+func (s *RedisCacheStore) indexDimension(ctx context.Context) (int, error) {
+	res, err := s.client.Do(ctx, "FT.INFO", s.indexName).Slice()
+	if err != nil {
+		return 0, fmt.Errorf("redis index info failed: %w", err)
+	}
+	// [..., "attributes", [[identifier, embedding, ..., dim, 1536, ...], ...], ...]
+	for i := 0; i+1 < len(res); i += 2 {
+		if k, _ := res[i].(string); k != "attributes" {
+			continue
+		}
+		attrs, _ := res[i+1].([]interface{})
+		for _, a := range attrs {
+			fields, _ := a.([]interface{})
+			if len(fields) < 2 || fmt.Sprint(fields[1]) != "embedding" {
+				continue
+			}
+			for j := 0; j+1 < len(fields); j += 2 {
+				if k, _ := fields[j].(string); strings.EqualFold(k, "dim") {
+					dim, _ := strconv.Atoi(fmt.Sprint(fields[j+1]))
+					return dim, nil
+				}
+			}
+		}
+	}
+	return 0, nil
+}
+
+func (s *RedisCacheStore) searchLookup(ctx context.Context, scope string, vec []float64) (*CacheEntry, float64, error) {
+	res, err := s.client.Do(ctx, "FT.SEARCH", s.indexName,
+		"(@scope:{"+scopeTag(scope)+"})=>[KNN 1 @embedding $vec AS distance]",
+		"PARAMS", "2", "vec", encodeVector(vec),
+		"SORTBY", "distance",
+		"RETURN", "5", "scope", "prompt", "response", "create_time", "distance",
+		"DIALECT", "2",
+	).Slice()
+	if err != nil {
+		return nil, 0, fmt.Errorf("redis search failed: %w", err)
+	}
+	// [total, key, [field, value, ...], ...]
+	if len(res) < 3 {
+		return nil, 0, nil
+	}
+	key, _ := res[1].(string)
+	raw, _ := res[2].([]interface{})
+	fields := make(map[string]string, len(raw)/2)
+	for i := 0; i+1 < len(raw); i += 2 {
+		k, _ := raw[i].(string)
+		v, _ := raw[i+1].(string)
+		fields[k] = v
+	}
+	dist, err := strconv.ParseFloat(fields["distance"], 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("redis search returned invalid distance %q", fields["distance"])
+	}
+	return s.entryFromFields(key, fields), 1 - dist, nil
+}
+
+func (s *RedisCacheStore) scanLookup(ctx context.Context, scope string, vec []float64) (*CacheEntry, float64, error) {
+	var best *CacheEntry
+	var bestSim float64
+	iter := s.client.Scan(ctx, 0, s.prefix+"*", 100).Iterator()
+	for n := 0; iter.Next(ctx); n++ {
+		if n == scanLimit {
+			s.scanCapped.Do(func() {
+				logger("redis_store").WarnContext(ctx, "Cache too large for key scans, lookups only compare the first keys scanned", "limit", scanLimit)
+			})
+			break
+		}
+		key := iter.Val()
+		fields, err := s.client.HGetAll(ctx, key).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			return nil, 0, fmt.Errorf("redis scan failed: %w", err)
+		}
+		if fields["scope"] != scopeTag(scope) {
+			continue
+		}
+		emb := decodeVector([]byte(fields["embedding"]))
+		if sim := cosineSimilarity(vec, emb); sim > bestSim {
+			best = s.entryFromFields(key, fields)
+			best.Embedding = emb
+			bestSim = sim
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, 0, fmt.Errorf("redis scan failed: %w", err)
+	}
+	return best, bestSim, nil
+}
+
+func (s *RedisCacheStore) entryFromFields(key string, fields map[string]string) *CacheEntry {
+	created, _ := strconv.ParseInt(fields["create_time"], 10, 64)
+	scope := fields["scope"]
+	if scope == unscopedTag {
+		scope = ""
+	}
+	return &CacheEntry{
+		ID:         strings.TrimPrefix(key, s.prefix),
+		Scope:      scope,
+		Prompt:     fields["prompt"],
+		Response:   []byte(fields["response"]),
+		CreateTime: time.Unix(0, created),
+	}
+}
+
+// unscopedTag stands in for the empty scope, RediSearch cannot match an empty tag
+const unscopedTag = "unscoped"
+
+// scopeTag maps a scope to its tag value, scopes are hex digests so they need no escaping
+func scopeTag(scope string) string {
+	if scope == "" {
+		return unscopedTag
+	}
+	return scope
+}
+
+// encodeVector packs the embedding as little-endian FLOAT32, the layout RediSearch expects
+func encodeVector(v []float64) []byte {
+	b := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(float32(x)))
+	}
+	return b
+}
+
+func decodeVector(b []byte) []float64 {
+	v := make([]float64, len(b)/4)
+	for i := range v {
+		v[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:])))
+	}
+	return v
+}
@@ -0,0 +1,105 @@
+package ext_proc
+
+import (
+	"context"
+	"time"
+
+	"github.com/kuadrant/inferno/internal/logging"
+)
+
+const requestIDHeader = "x-request-id"
+
+// RequestContext carries what we learn about a request through the phases of its ext_proc stream.
+// Each stream handles exactly one HTTP request, so the context lives as long as the stream.
+type RequestContext struct {
+	// id is Envoy's x-request-id, or a generated ID when the header is missing
+	id      string
+	headers map[string]string
+	control cacheControl
+	// route holds the settings of the matched route, see RouteConfig
+	route  RouteConfig
+	model  string
+	prompt string
+	// conversation is the structured conversation of the request, which the guard judges
+	conversation []Turn
+	// request is the parsed JSON request body, nil when the body is not JSON
+	request map[string]interface{}
+	// response is the parsed JSON response body of a buffered response, nil otherwise
+	response map[string]interface{}
+	// scope partitions the semantic cache, see ScopeConfig
+	scope     string
+	embedding []float64
+	// cache records the outcome of the cache lookup, reported back in the response headers
+	cache cacheResult
+	// guard records the decisions of the risk checks, reported back in the response headers
+	guard guardResult
+	// filters are the chain filters when the request started
+	filters []Filter
+	// stream accumulates an event stream response, nil for buffered responses
+	stream *sseAccumulator
+	start  time.Time
+	// trace carries the span of the whole stream, ctx the span of the phase being handled
+	trace context.Context
+	ctx   context.Context
+}
+
+func newRequestContext(headers map[string]string) *RequestContext {
+	id := headers[requestIDHeader]
+	if id == "" {
+		id = newEntryID()
+	}
+	// records logged with the request context carry its ID
+	ctx := logging.With(context.Background(), "request_id", id)
+	return &RequestContext{
+		id:      id,
+		headers: headers,
+		control: parseCacheControl(ctx, headers),
+		start:   time.Now(),
+		trace:   ctx,
+		ctx:     ctx,
+	}
+}
+
+// ID returns the request ID
+func (rc *RequestContext) ID() string {
+	return rc.id
+}
+
+// Context returns the context of the phase being handled, carrying its trace span and the request ID
+// for logging. Calls made on behalf of the request should derive from it.
+func (rc *RequestContext) Context() context.Context {
+	return rc.ctx
+}
+
+// Header returns a request header, names are lower case
+func (rc *RequestContext) Header(name string) string {
+	return rc.headers[name]
+}
+
+// Route returns the settings of the route the request matched
+func (rc *RequestContext) Route() RouteConfig {
+	return rc.route
+}
+
+// cacheTTL returns the expiry requested by the client, or the route's, zero keeps the cache default def.
+// The client can shorten the route or default expiry but not extend it.
+func (rc *RequestContext) cacheTTL(def time.Duration) time.Duration {
+	limit := def
+	if rc.route.CacheTTL > 0 {
+		limit = rc.route.CacheTTL
+	}
+	if rc.control.ttl > 0 && (limit <= 0 || rc.control.ttl < limit) {
+		return rc.control.ttl
+	}
+	return rc.route.CacheTTL
+}
+
+// Model returns the model named in the request body
+func (rc *RequestContext) Model() string {
+	return rc.model
+}
+
+// Prompt returns the prompt extracted from the request body, empty when none was found
+func (rc *RequestContext) Prompt() string {
+	return rc.prompt
+}
@@ -0,0 +1,102 @@
+package ext_proc
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// responseOutput is what the model generated in a buffered response
+type responseOutput struct {
+	// Text joins the text of every choice, output message or content block
+	Text      string
+	ToolCalls []ToolCall
+}
+
+// empty reports whether the response carries no generated output, such as an error body
+func (o responseOutput) empty() bool {
+	return o.Text == "" && len(o.ToolCalls) == 0
+}
+
+// extractResponseOutput returns the generated text and tool calls of a response: the choices of a
+// completion or chat completion, the output items of a Responses API response, or the content blocks of
+// an Anthropic style message
+func extractResponseOutput(body map[string]interface{}) responseOutput {
+	var out responseOutput
+	var texts []string
+	add := func(s string) {
+		if s != "" {
+			texts = append(texts, s)
+		}
+	}
+
+	// `/v1/completions` and `/v1/chat/completions`
+	if choices, ok := body["choices"].([]interface{}); ok {
+		for _, c := range choices {
+			cm, _ := c.(map[string]interface{})
+			if s, ok := cm["text"].(string); ok {
+				add(s)
+			}
+			if msg, ok := cm["message"].(map[string]interface{}); ok {
+				t := chatTurns([]interface{}{msg})[0]
+				add(t.Content)
+				out.ToolCalls = append(out.ToolCalls, t.ToolCalls...)
+			}
+		}
+	}
+
+	// `/v1/responses`
+	if items, ok := body["output"].([]interface{}); ok {
+		for _, item := range items {
+			im, _ := item.(map[string]interface{})
+			switch im["type"] {
+			case "message":
+				add(contentText(im["content"]))
+			case "function_call":
+				tc := functionCall(im)
+				tc.ID, _ = im["call_id"].(string)
+				out.ToolCalls = append(out.ToolCalls, tc)
+			}
+		}
+	}
+
+	// Anthropic `/v1/messages`
+	if blocks, ok := body["content"].([]interface{}); ok {
+		for _, block := range blocks {
+			bm, _ := block.(map[string]interface{})
+			switch bm["type"] {
+			case "text":
+				s, _ := bm["text"].(string)
+				add(s)
+			case "tool_use":
+				tc := ToolCall{}
+				tc.ID, _ = bm["id"].(string)
+				tc.Name, _ = bm["name"].(string)
+				if input, err := json.Marshal(bm["input"]); err == nil {
+					tc.Arguments = string(input)
+				}
+				out.ToolCalls = append(out.ToolCalls, tc)
+			}
+		}
+	}
+
+	out.Text = strings.Join(texts, "\n")
+	return out
+}
+
+// toolCallsJSON renders tool calls as the JSON list of {"name", "arguments"} objects Granite Guardian
+// judges function calls on
+func toolCallsJSON(calls []ToolCall) string {
+	type call struct {
+		Name      string      `json:"name"`
+		Arguments interface{} `json:"arguments"`
+	}
+	out := make([]call, len(calls))
+	for i, tc := range calls {
+		out[i] = call{Name: tc.Name, Arguments: tc.Arguments}
+		if json.Valid([]byte(tc.Arguments)) {
+			out[i].Arguments = json.RawMessage(tc.Arguments)
+		}
+	}
+	b, _ := json.Marshal(out)
+	return string(b)
+}
@@ -0,0 +1,52 @@
+package ext_proc
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Response output", func() {
+	parse := func(body string) responseOutput {
+		var bodyMap map[string]interface{}
+		Expect(json.Unmarshal([]byte(body), &bodyMap)).To(Succeed())
+		return extractResponseOutput(bodyMap)
+	}
+
+	It("should read every completion choice", func() {
+		Expect(parse(`{"object": "text_completion", "choices": [{"index": 0, "text": "One"}, {"index": 1, "text": "Two"}]}`)).
+			To(Equal(responseOutput{Text: "One\nTwo"}))
+	})
+
+	It("should read chat completion messages and tool calls", func() {
+		out := parse(`{"object": "chat.completion", "choices": [
+			{"index": 0, "message": {"role": "assistant", "content": "Let me check."}},
+			{"index": 1, "message": {"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]}}]}`)
+		Expect(out).To(Equal(responseOutput{
+			Text:      "Let me check.",
+			ToolCalls: []ToolCall{{ID: "call_1", Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+		}))
+		Expect(toolCallsJSON(out.ToolCalls)).To(Equal(`[{"name":"get_weather","arguments":{"city":"Paris"}}]`))
+	})
+
+	It("should read Responses API output items", func() {
+		out := parse(`{"object": "response", "output": [
+			{"type": "reasoning", "summary": []},
+			{"type": "message", "role": "assistant", "content": [{"type": "output_text", "text": "Sunny."}, {"type": "refusal", "refusal": "No."}]},
+			{"type": "function_call", "call_id": "call_1", "name": "get_weather", "arguments": "{}"}]}`)
+		Expect(out).To(Equal(responseOutput{Text: "Sunny.", ToolCalls: []ToolCall{{ID: "call_1", Name: "get_weather", Arguments: "{}"}}}))
+	})
+
+	It("should read Anthropic content blocks", func() {
+		out := parse(`{"type": "message", "role": "assistant", "content": [
+			{"type": "text", "text": "Checking."},
+			{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}]}`)
+		Expect(out).To(Equal(responseOutput{Text: "Checking.", ToolCalls: []ToolCall{{ID: "toolu_1", Name: "get_weather", Arguments: `{"city":"Paris"}`}}}))
+	})
+
+	It("should find no output in error bodies", func() {
+		Expect(parse(`{"error": {"message": "model overloaded"}}`).empty()).To(BeTrue())
+	})
+})
@@ -0,0 +1,173 @@
+package ext_proc
+
+import (
+	"slices"
+	"time"
+
+	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/kuadrant/inferno/internal/config"
+)
+
+const (
+	// routeMetadataNamespace is the metadata namespace holding inferno settings, both in route metadata
+	// and in the dynamic metadata Envoy forwards with metadata_options
+	routeMetadataNamespace = "inferno"
+	// extProcAttributesKey is the key Envoy groups the attributes listed in request_attributes under
+	extProcAttributesKey = "envoy.filters.http.ext_proc"
+)
+
+// RouteConfig holds the settings of the route a request matched. Unset fields keep the global defaults
+// from the configuration, so routes only need to set what differs.
+//
+// Settings are read from the inferno namespace of the route metadata (the xds.route_metadata attribute)
+// and of the forwarded dynamic metadata, the latter taking precedence:
+//
+//	semantic_cache: {enabled: false, similarity_threshold: 0.9, ttl: 10m}
+//	prompt_guard: {check_prompt: true, check_response: false, prompt_risks: [harm, jailbreak], response_risks: [groundedness]}
+//	token_metrics: {enabled: false}
+type RouteConfig struct {
+	// Name is the route name from the xds.route_name attribute, empty when not requested
+	Name                string
+	CacheEnabled        *bool
+	SimilarityThreshold *float64
+	// CacheTTL is how long responses on this route are cached, zero keeps the default
+	CacheTTL      time.Duration
+	PromptCheck   *bool
+	ResponseCheck *bool
+	// PromptRisks and ResponseRisks are the guardian risks checked on this route, nil keeps the default
+	PromptRisks   []string
+	ResponseRisks []string
+	TokenMetrics  *bool
+}
+
+// update merges the route settings carried by an ext_proc message, Envoy sends them with the first message
+// of the stream but later messages may add dynamic metadata set by other filters
+func (r *RouteConfig) update(req *extProcPb.ProcessingRequest) {
+	if attrs := req.GetAttributes()[extProcAttributesKey].GetFields(); attrs != nil {
+		if name := attrs["xds.route_name"].GetStringValue(); name != "" {
+			r.Name = name
+		}
+		routeMeta := attrs["xds.route_metadata"].GetStructValue().GetFields()["filter_metadata"].GetStructValue()
+		r.apply(routeMeta.GetFields()[routeMetadataNamespace].GetStructValue())
+	}
+	r.apply(req.GetMetadataContext().GetFilterMetadata()[routeMetadataNamespace])
+}
+
+// apply overrides the settings present in s, invalid values are logged and ignored
+func (r *RouteConfig) apply(s *structpb.Struct) {
+	if s == nil {
+		return
+	}
+	section := func(name string) map[string]*structpb.Value {
+		return s.GetFields()[name].GetStructValue().GetFields()
+	}
+
+	cache := section("semantic_cache")
+	setBool(&r.CacheEnabled, cache["enabled"])
+	if v, ok := cache["similarity_threshold"].GetKind().(*structpb.Value_NumberValue); ok {
+		if v.NumberValue < 0 || v.NumberValue > 1 {
+			logger("route_config").Warn("Ignoring invalid similarity_threshold", "route", r.Name, "value", v.NumberValue)
+		} else {
+			t := v.NumberValue
+			r.SimilarityThreshold = &t
+		}
+	}
+	switch v := cache["ttl"].GetKind().(type) {
+	case *structpb.Value_StringValue:
+		if ttl, ok := parseTTL(v.StringValue); ok {
+			r.CacheTTL = ttl
+		} else {
+			logger("route_config").Warn("Ignoring invalid ttl", "route", r.Name, "value", v.StringValue)
+		}
+	case *structpb.Value_NumberValue:
+		if v.NumberValue > 0 {
+			r.CacheTTL = time.Duration(v.NumberValue * float64(time.Second))
+		}
+	}
+
+	guard := section("prompt_guard")
+	setBool(&r.PromptCheck, guard["check_prompt"])
+	setBool(&r.ResponseCheck, guard["check_response"])
+	r.setRisks(&r.PromptRisks, "prompt_risks", guard["prompt_risks"], config.PromptRisks)
+	r.setRisks(&r.ResponseRisks, "response_risks", guard["response_risks"], config.ResponseRisks)
+
+	setBool(&r.TokenMetrics, section("token_metrics")["enabled"])
+}
+
+func setBool(dst **bool, v *structpb.Value) {
+	if b, ok := v.GetKind().(*structpb.Value_BoolValue); ok {
+		val := b.BoolValue
+		*dst = &val
+	}
+}
+
+// setRisks reads a list of risk names, ignoring the list when it is empty or names a risk not in allowed
+func (r *RouteConfig) setRisks(dst *[]string, key string, v *structpb.Value, allowed []string) {
+	list, ok := v.GetKind().(*structpb.Value_ListValue)
+	if !ok {
+		return
+	}
+	var risks []string
+	for _, item := range list.ListValue.GetValues() {
+		risk := item.GetStringValue()
+		if !slices.Contains(allowed, risk) {
+			logger("route_config").Warn("Ignoring invalid "+key, "route", r.Name, "risk", risk)
+			return
+		}
+		risks = append(risks, risk)
+	}
+	if len(risks) > 0 {
+		*dst = risks
+	}
+}
+
+// cacheEnabled reports whether the semantic cache runs on this route
+func (r RouteConfig) cacheEnabled() bool {
+	return r.CacheEnabled == nil || *r.CacheEnabled
+}
+
+// similarityThreshold returns the route threshold or def
+func (r RouteConfig) similarityThreshold(def float64) float64 {
+	if r.SimilarityThreshold != nil {
+		return *r.SimilarityThreshold
+	}
+	return def
+}
+
+// promptCheck reports whether prompts are risk checked on this route, def applies to routes that don't say
+func (r RouteConfig) promptCheck(def bool) bool {
+	if r.PromptCheck != nil {
+		return *r.PromptCheck
+	}
+	return def
+}
+
+// responseCheck reports whether responses are risk checked on this route, def applies to routes that don't say
+func (r RouteConfig) responseCheck(def bool) bool {
+	if r.ResponseCheck != nil {
+		return *r.ResponseCheck
+	}
+	return def
+}
+
+// risks returns the guardian risks checked in a direction on this route, the configured ones when the
+// route doesn't say
+func (r RouteConfig) risks(direction string, cfg *config.PromptGuardConfig) []string {
+	if direction == "prompt" {
+		if r.PromptRisks != nil {
+			return r.PromptRisks
+		}
+		return cfg.Risks.Prompt
+	}
+	if r.ResponseRisks != nil {
+		return r.ResponseRisks
+	}
+	return cfg.Risks.Response
+}
+
+// tokenMetrics reports whether token usage headers are added on this route
+func (r RouteConfig) tokenMetrics() bool {
+	return r.TokenMetrics == nil || *r.TokenMetrics
+}
@@ -0,0 +1,112 @@
+package ext_proc
+
+import (
+	"time"
+
+	corePb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/kuadrant/inferno/internal/config"
+)
+
+var _ = Describe("RouteConfig", func() {
+	mustStruct := func(m map[string]interface{}) *structpb.Struct {
+		s, err := structpb.NewStruct(m)
+		Expect(err).NotTo(HaveOccurred())
+		return s
+	}
+
+	withAttributes := func(attrs map[string]interface{}) *extProcPb.ProcessingRequest {
+		return &extProcPb.ProcessingRequest{
+			Attributes: map[string]*structpb.Struct{extProcAttributesKey: mustStruct(attrs)},
+		}
+	}
+
+	withDynamicMetadata := func(settings map[string]interface{}) *extProcPb.ProcessingRequest {
+		return &extProcPb.ProcessingRequest{
+			MetadataContext: &corePb.Metadata{
+				FilterMetadata: map[string]*structpb.Struct{routeMetadataNamespace: mustStruct(settings)},
+			},
+		}
+	}
+
+	It("should keep the global defaults when nothing is set", func() {
+		var r RouteConfig
+		r.update(&extProcPb.ProcessingRequest{})
+		Expect(r.cacheEnabled()).To(BeTrue())
+		Expect(r.similarityThreshold(0.75)).To(Equal(0.75))
+		Expect(r.CacheTTL).To(BeZero())
+		Expect(r.promptCheck(false)).To(BeFalse())
+		Expect(r.responseCheck(true)).To(BeTrue())
+		Expect(r.tokenMetrics()).To(BeTrue())
+	})
+
+	It("should read the route name and route metadata from the attributes", func() {
+		var r RouteConfig
+		r.update(withAttributes(map[string]interface{}{
+			"xds.route_name": "kserve-chat",
+			"xds.route_metadata": map[string]interface{}{
+				"filter_metadata": map[string]interface{}{
+					"inferno": map[string]interface{}{
+						"semantic_cache": map[string]interface{}{"similarity_threshold": 0.9, "ttl": "10m"},
+						"token_metrics":  map[string]interface{}{"enabled": false},
+					},
+				},
+			},
+		}))
+		Expect(r.Name).To(Equal("kserve-chat"))
+		Expect(r.similarityThreshold(0.75)).To(Equal(0.9))
+		Expect(r.CacheTTL).To(Equal(10 * time.Minute))
+		Expect(r.tokenMetrics()).To(BeFalse())
+	})
+
+	It("should let dynamic metadata override the route metadata", func() {
+		var r RouteConfig
+		r.update(withAttributes(map[string]interface{}{
+			"xds.route_metadata": map[string]interface{}{
+				"filter_metadata": map[string]interface{}{
+					"inferno": map[string]interface{}{"semantic_cache": map[string]interface{}{"enabled": true, "ttl": 60}},
+				},
+			},
+		}))
+		r.update(withDynamicMetadata(map[string]interface{}{
+			"semantic_cache": map[string]interface{}{"enabled": false},
+			"prompt_guard":   map[string]interface{}{"check_prompt": true, "check_response": false},
+		}))
+		Expect(r.cacheEnabled()).To(BeFalse())
+		Expect(r.CacheTTL).To(Equal(time.Minute))
+		Expect(r.promptCheck(false)).To(BeTrue())
+		Expect(r.responseCheck(true)).To(BeFalse())
+	})
+
+	It("should ignore invalid values", func() {
+		var r RouteConfig
+		r.update(withDynamicMetadata(map[string]interface{}{
+			"semantic_cache": map[string]interface{}{"enabled": "no", "similarity_threshold": 2, "ttl": "soon"},
+		}))
+		Expect(r.CacheEnabled).To(BeNil())
+		Expect(r.SimilarityThreshold).To(BeNil())
+		Expect(r.CacheTTL).To(BeZero())
+	})
+
+	It("should read the guardian risks of each direction", func() {
+		defaults := &config.PromptGuardConfig{Risks: config.GuardRisksConfig{Prompt: []string{"harm"}, Response: []string{"harm"}}}
+		var r RouteConfig
+		r.update(withDynamicMetadata(map[string]interface{}{
+			"prompt_guard": map[string]interface{}{
+				"prompt_risks":   []interface{}{"jailbreak", "harm"},
+				"response_risks": []interface{}{"harm", "not_a_risk"},
+			},
+		}))
+		Expect(r.risks("prompt", defaults)).To(Equal([]string{"jailbreak", "harm"}))
+		Expect(r.risks("response", defaults)).To(Equal([]string{"harm"}))
+
+		r.update(withDynamicMetadata(map[string]interface{}{
+			"prompt_guard": map[string]interface{}{"prompt_risks": []interface{}{"groundedness"}},
+		}))
+		Expect(r.risks("prompt", defaults)).To(Equal([]string{"jailbreak", "harm"}))
+	})
+})
@@ -0,0 +1,80 @@
+package ext_proc
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/kuadrant/inferno/internal/config"
+)
+
+// RuleGuard is a local backend flagging texts that match its rules, by regular expression or deny-listed
+// keyword. The flagged categories are the names of the matching rules.
+type RuleGuard struct {
+	name  string
+	rules []guardRule
+}
+
+type guardRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// NewRuleGuard compiles the rules, keywords match as whole words or phrases ignoring case
+func NewRuleGuard(name string, rules []config.GuardRuleConfig) (*RuleGuard, error) {
+	g := &RuleGuard{name: name}
+	for _, r := range rules {
+		if r.Pattern != "" {
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", r.Name, err)
+			}
+			g.rules = append(g.rules, guardRule{r.Name, re})
+		}
+		if len(r.Keywords) > 0 {
+			quoted := make([]string, len(r.Keywords))
+			for i, k := range r.Keywords {
+				quoted[i] = regexp.QuoteMeta(strings.TrimSpace(k))
+			}
+			g.rules = append(g.rules, guardRule{r.Name, regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)})
+		}
+	}
+	return g, nil
+}
+
+// newFallbackGuard builds the local detector of the degrade policy from its patterns, invalid ones are
+// logged and skipped
+func newFallbackGuard(patterns []string) *RuleGuard {
+	g := &RuleGuard{name: "fallback"}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			logger("prompt_guard").Warn("Ignoring invalid fallback pattern", "pattern", p, "error", err)
+			continue
+		}
+		g.rules = append(g.rules, guardRule{"fallback", re})
+	}
+	return g
+}
+
+func (g *RuleGuard) Name() string {
+	return g.name
+}
+
+// Check never fails, the rules run locally
+func (g *RuleGuard) Check(ctx context.Context, in GuardInput) ([]string, error) {
+	return g.match(in.text()), nil
+}
+
+// match returns the names of the rules matching text
+func (g *RuleGuard) match(text string) []string {
+	var names []string
+	for _, r := range g.rules {
+		if !slices.Contains(names, r.name) && r.re.MatchString(text) {
+			names = append(names, r.name)
+		}
+	}
+	return names
+}
@@ -1,216 +1,416 @@
 package ext_proc
 
 import (
-	"encoding/json"
-	"io"
-	"log"
-	"math"
+	"context"
+	"errors"
+	"fmt"
 	"os"
-	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
 	typeV3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/kuadrant/inferno/internal/config"
+	"github.com/kuadrant/inferno/internal/logging"
+	"github.com/kuadrant/inferno/internal/metrics"
+	"github.com/kuadrant/inferno/internal/tracing"
 )
 
 // CacheEntry holds prompt, its embedding, and the cached response
 type CacheEntry struct {
+	ID string
+	// Scope partitions the cache, lookups only match entries with the same scope
+	Scope      string
 	Prompt     string
 	Embedding  []float64
 	Response   []byte
 	CreateTime time.Time
 }
 
+// size approximates the memory held by the entry
+func (e *CacheEntry) size() int64 {
+	return int64(len(e.ID)+len(e.Scope)+len(e.Prompt)+len(e.Response)+8*len(e.Embedding)) + 64
+}
+
+// cacheStoreTimeout bounds store calls made in the request path
+const cacheStoreTimeout = time.Second
+
 type SemanticCache struct {
-	semanticCache       []*CacheEntry
-	embeddingCache      sync.Map
-	embeddingServerURL  string
-	embeddingModelHost  string
-	similarityThreshold float64
-	cacheMutex          sync.Mutex
+	BaseFilter
+	store          CacheStore
+	embeddingCache *boundedCache[string, []float64]
+	embedder       EmbeddingProvider
+	// provider names the embedding provider in metrics
+	provider string
+	// ttl is the default expiry of the store, it bounds the expiry clients request
+	ttl time.Duration
+	// settings is swapped on reload, requests read the similarity threshold and scope from it
+	settings     atomic.Pointer[config.SemanticCacheConfig]
+	snapshotPath string
+	stop         chan struct{}
+	wg           sync.WaitGroup
+	closeOnce    sync.Once
 }
 
+// NewSemanticCache builds a cache configured from environment variables
 func NewSemanticCache() *SemanticCache {
-	embeddingServerURL := os.Getenv("EMBEDDING_MODEL_SERVER")
-	embeddingModelHost := os.Getenv("EMBEDDING_MODEL_HOST")
-	log.Printf("[SemanticCache] EMBEDDING_MODEL_SERVER=%s", embeddingServerURL)
-	log.Printf("[SemanticCache] EMBEDDING_MODEL_HOST=%s", embeddingModelHost)
-
-	similarityThreshold := 0.75
-	if ts := os.Getenv("SIMILARITY_THRESHOLD"); ts != "" {
-		if v, err := strconv.ParseFloat(ts, 64); err == nil {
-			similarityThreshold = v
-			log.Printf("[SemanticCache] similarityThreshold=%.3f", similarityThreshold)
+	return NewSemanticCacheWithConfig(configFromEnv())
+}
+
+func NewSemanticCacheWithConfig(cfg *config.Config) *SemanticCache {
+	log := logger("semantic_cache")
+	embeddingConfig := embeddingProviderConfig(cfg.Embedding)
+	log.Info("Embedding provider configured", "provider", embeddingProviderName(embeddingConfig), "url", embeddingConfig.URL, "host", embeddingConfig.Host)
+	embedder, err := NewEmbeddingProvider(embeddingConfig)
+	if err != nil {
+		log.Warn("Embeddings disabled", "error", err)
+	}
+
+	entryLimits := cacheLimits(cfg.SemanticCache.CacheLimits)
+	embeddingLimits := cacheLimits(cfg.Embedding.Cache)
+	log.Info("Semantic cache configured",
+		"similarity_threshold", cfg.SemanticCache.SimilarityThreshold,
+		"entry_limits", fmt.Sprintf("%+v", entryLimits),
+		"embedding_limits", fmt.Sprintf("%+v", embeddingLimits),
+		"scope", fmt.Sprintf("%+v", cfg.SemanticCache.Scope))
+
+	sc := &SemanticCache{
+		store:        newCacheStore(cfg.SemanticCache, entryLimits),
+		embedder:     embedder,
+		provider:     embeddingProviderName(embeddingConfig),
+		ttl:          entryLimits.TTL,
+		snapshotPath: cfg.SemanticCache.Snapshot.Path,
+		stop:         make(chan struct{}),
+	}
+	sc.Reload(cfg.SemanticCache)
+	sc.embeddingCache = newBoundedCache(embeddingLimits,
+		func(prompt string, emb []float64) int64 { return int64(len(prompt) + 8*len(emb)) },
+		nil)
+
+	if sc.snapshotPath != "" {
+		log.Info("Snapshots enabled", "path", sc.snapshotPath)
+		sc.restoreSnapshot()
+		if interval := time.Duration(cfg.SemanticCache.Snapshot.Interval); interval > 0 {
+			sc.every(interval, sc.saveSnapshot)
 		}
 	}
+	if interval := time.Duration(cfg.SemanticCache.SweepInterval); interval > 0 {
+		sc.every(interval, sc.sweep)
+	}
+	return sc
+}
 
-	return &SemanticCache{
-		semanticCache:       []*CacheEntry{},
-		embeddingServerURL:  embeddingServerURL,
-		embeddingModelHost:  embeddingModelHost,
-		similarityThreshold: similarityThreshold,
+// Reload applies the settings that can change while running, the similarity threshold and the scope.
+// Requests already past the lookup are not affected.
+func (sc *SemanticCache) Reload(cfg config.SemanticCacheConfig) {
+	if sc.provider == EmbeddingProviderLocal && cfg.SimilarityThreshold < localMinThreshold {
+		logger("semantic_cache").Warn("Similarity threshold too low for the local embedding provider, prompts asking different questions will match",
+			"similarity_threshold", cfg.SimilarityThreshold, "recommended", localMinThreshold)
 	}
+	sc.settings.Store(&cfg)
+}
+
+// every runs fn on a ticker until the cache is closed
+func (sc *SemanticCache) every(interval time.Duration, fn func()) {
+	sc.wg.Add(1)
+	go func() {
+		defer sc.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fn()
+			case <-sc.stop:
+				return
+			}
+		}
+	}()
 }
 
-func (sc *SemanticCache) cosineSimilarity(a, b []float64) float64 {
-	var dot, na, nb float64
-	for i := range a {
-		dot += a[i] * b[i]
-		na += a[i] * a[i]
-		nb += b[i] * b[i]
+// sweep drops expired entries so they don't hold memory until the next lookup
+func (sc *SemanticCache) sweep() {
+	var entries int
+	if ms, ok := sc.store.(*MemoryCacheStore); ok {
+		entries = ms.Sweep()
 	}
-	if na == 0 || nb == 0 {
-		return 0
+	embeddings := sc.embeddingCache.Sweep()
+	if entries > 0 || embeddings > 0 {
+		logger("semantic_cache").Debug("Swept expired entries", "entries", entries, "embeddings", embeddings)
 	}
-	return dot / (math.Sqrt(na) * math.Sqrt(nb))
 }
 
-func (sc *SemanticCache) findMostSimilarPrompt(vec []float64) (*CacheEntry, float64) {
-	sc.cacheMutex.Lock()
-	defer sc.cacheMutex.Unlock()
-	var best *CacheEntry
-	var bestSim float64
-	for _, e := range sc.semanticCache {
-		if s := sc.cosineSimilarity(vec, e.Embedding); s > bestSim {
-			bestSim, best = s, e
+// saveSnapshot persists the embedding cache, and the entries when they are held in memory
+func (sc *SemanticCache) saveSnapshot() {
+	snap := &cacheSnapshot{}
+	if ms, ok := sc.store.(*MemoryCacheStore); ok {
+		snap.Entries = ms.snapshotEntries()
+	}
+	sc.embeddingCache.Range(func(prompt string, emb []float64, expires time.Time) bool {
+		snap.Embeddings = append(snap.Embeddings, snapshotEmbedding{Prompt: prompt, Embedding: emb, Expires: expires})
+		return true
+	})
+	if err := saveSnapshot(sc.snapshotPath, snap); err != nil {
+		logger("semantic_cache").Error("Failed to save snapshot", "error", err)
+		return
+	}
+	logger("semantic_cache").Debug("Saved snapshot", "entries", len(snap.Entries), "embeddings", len(snap.Embeddings))
+}
+
+// restoreSnapshot loads a previous snapshot, a missing or corrupt snapshot leaves the cache empty
+func (sc *SemanticCache) restoreSnapshot() {
+	snap, err := loadSnapshot(sc.snapshotPath)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logger("semantic_cache").Warn("Skipping snapshot", "path", sc.snapshotPath, "error", err)
 		}
+		return
+	}
+	var entries int
+	if ms, ok := sc.store.(*MemoryCacheStore); ok {
+		entries = ms.restoreEntries(snap.Entries)
 	}
-	return best, bestSim
+	embeddings := 0
+	now := time.Now()
+	for _, se := range snap.Embeddings {
+		if !se.Expires.IsZero() && !now.Before(se.Expires) {
+			continue
+		}
+		sc.embeddingCache.SetExpiry(se.Prompt, se.Embedding, se.Expires)
+		embeddings++
+	}
+	logger("semantic_cache").Info("Restored snapshot", "entries", entries, "embeddings", embeddings)
 }
 
-func (sc *SemanticCache) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
-	log.Println("[SemanticCache] Starting processing loop")
-	var lastPrompt string
-
-	for {
-		req, err := srv.Recv()
-		if err == io.EOF {
-			log.Println("[SemanticCache] EOF, exiting")
-			return nil
-		} else if err != nil {
-			log.Printf("[SemanticCache] Recv error: %v", err)
-			return status.Errorf(codes.Unknown, "recv error: %v", err)
+// Close stops background work, writes a final snapshot and releases the cache store
+func (sc *SemanticCache) Close() {
+	sc.closeOnce.Do(func() {
+		close(sc.stop)
+		sc.wg.Wait()
+		if sc.snapshotPath != "" {
+			sc.saveSnapshot()
 		}
+		if err := sc.store.Close(); err != nil {
+			logger("semantic_cache").Error("Failed to close cache store", "error", err)
+		}
+	})
+}
 
-		var resp *extProcPb.ProcessingResponse
-		log.Printf("[SemanticCache] Handling %T", req.Request)
+// findMostSimilarPrompt returns the nearest live entry, store errors are logged and count as a miss
+func (sc *SemanticCache) findMostSimilarPrompt(ctx context.Context, scope string, vec []float64) (*CacheEntry, float64) {
+	ctx, span := tracing.Tracer().Start(ctx, "semantic_cache.find_most_similar_prompt")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, cacheStoreTimeout)
+	defer cancel()
+	e, sim, err := sc.store.Lookup(ctx, scope, vec)
+	if err != nil {
+		logger("semantic_cache").ErrorContext(ctx, "Cache lookup failed", "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, 0
+	}
+	span.SetAttributes(attribute.Bool("inferno.cache.candidate", e != nil))
+	if e != nil {
+		span.SetAttributes(attribute.Float64("inferno.cache.similarity", sim))
+	}
+	return e, sim
+}
 
-		switch r := req.Request.(type) {
+// embedding returns the prompt embedding from the embedding cache, fetching it on a miss
+func (sc *SemanticCache) embedding(ctx context.Context, prompt string) []float64 {
+	if emb, ok := sc.embeddingCache.Get(prompt); ok {
+		logger("semantic_cache").DebugContext(ctx, "Exact match cache hit for embedding")
+		return emb
+	}
+	if sc.embedder == nil {
+		return nil
+	}
+	ctx, span := tracing.Tracer().Start(ctx, "semantic_cache.fetch_embedding",
+		trace.WithAttributes(attribute.String("inferno.embedding.provider", sc.provider)))
+	defer span.End()
+	start := time.Now()
+	embs, err := sc.embedder.Embed(ctx, []string{prompt})
+	metrics.EmbeddingDuration.WithLabelValues(sc.provider, metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		logger("semantic_cache").ErrorContext(ctx, "Failed to fetch embedding", "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil
+	}
+	emb := embs[0]
+	if len(emb) > 0 {
+		sc.embeddingCache.Set(prompt, emb, 0)
+		logger("semantic_cache").DebugContext(ctx, "Stored new embedding", "dimensions", len(emb))
+	}
+	return emb
+}
 
-		case *extProcPb.ProcessingRequest_RequestHeaders:
-			resp = &extProcPb.ProcessingResponse{Response: &extProcPb.ProcessingResponse_RequestHeaders{RequestHeaders: &extProcPb.HeadersResponse{}}}
+// addEntry stores a response for the prompt under its embedding, ttl <= 0 uses the configured expiry
+func (sc *SemanticCache) addEntry(ctx context.Context, scope, prompt string, emb []float64, response []byte, ttl time.Duration) bool {
+	if len(emb) == 0 {
+		return false
+	}
+	e := &CacheEntry{
+		ID:         newEntryID(),
+		Scope:      scope,
+		Prompt:     prompt,
+		Embedding:  emb,
+		Response:   response,
+		CreateTime: time.Now(),
+	}
+	ctx, cancel := context.WithTimeout(ctx, cacheStoreTimeout)
+	defer cancel()
+	if err := sc.store.Store(ctx, e, ttl); err != nil {
+		logger("semantic_cache").ErrorContext(ctx, "Failed to store cache entry", "error", err)
+		return false
+	}
+	return true
+}
 
-		case *extProcPb.ProcessingRequest_RequestBody:
-			rb := r.RequestBody
-			log.Printf("[SemanticCache] RequestBody, end_of_stream=%v", rb.EndOfStream)
-			if !rb.EndOfStream {
-				srv.Send(&extProcPb.ProcessingResponse{Response: &extProcPb.ProcessingResponse_RequestBody{RequestBody: &extProcPb.BodyResponse{}}})
-				continue
-			}
+// newCacheStore builds the configured store, falling back to memory on misconfiguration
+func newCacheStore(cfg config.SemanticCacheConfig, limits CacheLimits) CacheStore {
+	log := logger("semantic_cache")
+	log.Info("Cache store configured", "store", cfg.Store, "index", cfg.Index)
 
-			// parse JSON once complete
-			var pl map[string]interface{}
-			if err := json.Unmarshal(rb.Body, &pl); err != nil {
-				log.Printf("[SemanticCache] JSON parse failed: %v", err)
-				resp = &extProcPb.ProcessingResponse{Response: &extProcPb.ProcessingResponse_RequestBody{RequestBody: &extProcPb.BodyResponse{}}}
-				break
-			}
+	if cfg.Store == "redis" {
+		store, err := NewRedisCacheStore(RedisCacheStoreConfig{
+			URL:       cfg.Redis.URL,
+			Prefix:    cfg.Redis.Prefix,
+			IndexName: cfg.Redis.Index,
+			TTL:       limits.TTL,
+		})
+		if err == nil {
+			return store
+		}
+		log.Error("Failed to create redis store, using memory", "error", err)
+	}
 
-			// extract prompt
-			if raw, ok := pl["prompt"]; ok {
-				if prompt, ok2 := raw.(string); ok2 {
-					log.Printf("[SemanticCache] Prompt: %s", prompt)
-					lastPrompt = prompt
-
-					// lookup embedding
-					var emb []float64
-					if v, ok3 := sc.embeddingCache.Load(prompt); ok3 {
-						emb = v.([]float64)
-						log.Println("[SemanticCache] Exact match cache hit for embedding")
-					} else if sc.embeddingServerURL != "" {
-						emb = fetchEmbedding(sc.embeddingServerURL, sc.embeddingModelHost, prompt)
-						if emb != nil {
-							sc.embeddingCache.Store(prompt, emb)
-							log.Printf("[SemanticCache] Stored new embedding len=%d", len(emb))
-						}
-					}
-
-					// similarity logging
-					if len(emb) > 0 {
-						log.Printf("[SemanticCache] Semantic lookup on %d entries", len(sc.semanticCache))
-						e, sim := sc.findMostSimilarPrompt(emb)
-						if e != nil {
-							log.Printf("[SemanticCache] Best candidate: %s with similarity=%.3f (threshold=%.3f)", e.Prompt, sim, sc.similarityThreshold)
-							if sim >= sc.similarityThreshold && e.Response != nil {
-								log.Printf("[SemanticCache] similarity %.3f >= threshold %.3f; cache HIT", sim, sc.similarityThreshold)
-
-								// extract token metrics headers from cached response
-								headers := ExtractTokenMetricsHeaders(e.Response)
-
-								if headers != nil {
-									log.Printf("[SemanticCache] Found token metrics in cached response")
-									// return cached response with token metrics headers
-									srv.Send(&extProcPb.ProcessingResponse{
-										Response: &extProcPb.ProcessingResponse_ImmediateResponse{
-											ImmediateResponse: &extProcPb.ImmediateResponse{
-												Status: &typeV3.HttpStatus{Code: 200},
-												Body:   e.Response,
-												Headers: &extProcPb.HeaderMutation{
-													SetHeaders: headers,
-												},
-											},
-										},
-									})
-								} else {
-									// no metrics found, return cached response as-is
-									srv.Send(&extProcPb.ProcessingResponse{
-										Response: &extProcPb.ProcessingResponse_ImmediateResponse{
-											ImmediateResponse: &extProcPb.ImmediateResponse{
-												Status: &typeV3.HttpStatus{Code: 200},
-												Body:   e.Response,
-											},
-										},
-									})
-								}
-								continue
-							} else {
-								log.Printf("[SemanticCache] similarity %.3f < threshold %.3f; no cache hit", sim, sc.similarityThreshold)
-							}
-						} else {
-							log.Println("[SemanticCache] semanticCache empty; no candidate to compare")
-						}
-					}
-				}
-			}
+	return NewMemoryCacheStore(func() VectorIndex { return NewVectorIndex(cfg.Index) }, limits)
+}
 
-			// pass through request body
-			resp = &extProcPb.ProcessingResponse{Response: &extProcPb.ProcessingResponse_RequestBody{RequestBody: &extProcPb.BodyResponse{}}}
-
-		case *extProcPb.ProcessingRequest_ResponseHeaders:
-			resp = &extProcPb.ProcessingResponse{Response: &extProcPb.ProcessingResponse_ResponseHeaders{ResponseHeaders: &extProcPb.HeadersResponse{}}}
-
-		case *extProcPb.ProcessingRequest_ResponseBody:
-			rb := r.ResponseBody
-			log.Printf("[SemanticCache] ResponseBody, end_of_stream=%v", rb.EndOfStream)
-			if rb.EndOfStream && lastPrompt != "" {
-				sc.cacheMutex.Lock()
-				if embI, ok := sc.embeddingCache.Load(lastPrompt); ok {
-					emb := embI.([]float64)
-					sc.semanticCache = append(sc.semanticCache, &CacheEntry{Prompt: lastPrompt, Embedding: emb, Response: rb.Body, CreateTime: time.Now()})
-					log.Printf("[SemanticCache] Added semanticCache entry for %s", lastPrompt)
-				}
-				sc.cacheMutex.Unlock()
-			}
-			resp = &extProcPb.ProcessingResponse{Response: &extProcPb.ProcessingResponse_ResponseBody{ResponseBody: &extProcPb.BodyResponse{}}}
+func (sc *SemanticCache) Name() string {
+	return "semantic-cache"
+}
+
+// OnRequestHeaders strips the inferno cache headers, they are meant for us and shouldn't leak upstream
+func (sc *SemanticCache) OnRequestHeaders(rc *RequestContext) PhaseResult {
+	return PhaseResult{RemoveHeaders: []string{cacheThresholdHeader, cacheTTLHeader}}
+}
+
+// OnRequestBody answers the request from the cache when a similar prompt was seen before
+func (sc *SemanticCache) OnRequestBody(rc *RequestContext, body []byte) PhaseResult {
+	if rc.prompt == "" {
+		return PhaseResult{}
+	}
+	log := logger("semantic_cache")
+	if !rc.route.cacheEnabled() {
+		log.DebugContext(rc.Context(), "Cache disabled for route", "route", rc.route.Name)
+		return PhaseResult{}
+	}
+	defer func() { rc.cache.record(rc.route.Name) }()
+	settings := sc.settings.Load()
+	rc.scope = scopeConfig(settings.Scope).Key(rc.request, rc.headers)
+
+	// the embedding is skipped when the client opted out of the cache entirely
+	if !rc.control.noCache || !rc.control.noStore {
+		rc.embedding = sc.embedding(rc.Context(), rc.prompt)
+	}
+	if rc.control.noCache {
+		log.DebugContext(rc.Context(), "Cache lookup bypassed by request headers")
+		rc.cache = cacheResult{status: cacheBypass}
+		return PhaseResult{}
+	}
+	if len(rc.embedding) == 0 {
+		return PhaseResult{}
+	}
+
+	rc.cache = cacheResult{status: cacheMiss}
+	threshold := rc.control.similarityThreshold(rc.route.similarityThreshold(settings.SimilarityThreshold))
+	e, sim := sc.findMostSimilarPrompt(rc.Context(), rc.scope, rc.embedding)
+	if e == nil {
+		return PhaseResult{}
+	}
+	rc.cache.similarity, rc.cache.hasCandidate = sim, true
+	if sim < threshold || e.Response == nil || !rc.control.acceptsAge(e.CreateTime) {
+		log.DebugContext(rc.Context(), "No cache hit", "similarity", sim, "threshold", threshold)
+		return PhaseResult{}
+	}
+
+	log.InfoContext(rc.Context(), "Semantic cache hit", "similarity", sim, "entry", e.ID, "prompt", logging.Text(rc.prompt))
+	rc.cache.status, rc.cache.entry = cacheHit, e
 
-		default:
-			resp = &extProcPb.ProcessingResponse{}
+	// extract token metrics headers from cached response
+	headers := ExtractTokenMetricsHeaders(e.Response)
+	if headers != nil {
+		log.DebugContext(rc.Context(), "Found token metrics in cached response")
+	}
+
+	// streaming clients can't parse the buffered body, replay it as the stream they asked for
+	respBody := e.Response
+	headers = append(headers, rc.cache.headers()...)
+	if stream, includeUsage := wantsStream(rc.request); stream {
+		if rendered, ok := renderEventStream(e.Response, includeUsage); ok {
+			respBody = rendered
+			headers = append(headers,
+				cacheHeader("content-type", "text/event-stream"),
+				cacheHeader("cache-control", "no-cache"),
+			)
 		}
+	}
+
+	// return cached response with token metrics and cache headers
+	return PhaseResult{
+		Immediate: &extProcPb.ImmediateResponse{
+			Status: &typeV3.HttpStatus{Code: 200},
+			Body:   respBody,
+			Headers: &extProcPb.HeaderMutation{
+				SetHeaders: headers,
+			},
+		},
+	}
+}
+
+// OnResponseHeaders reports the outcome of the lookup
+func (sc *SemanticCache) OnResponseHeaders(rc *RequestContext, headers map[string]string) PhaseResult {
+	return PhaseResult{SetHeaders: rc.cache.headers()}
+}
 
-		// send response
-		srv.Send(resp)
+// OnResponseBody stores the response, an event stream is stored as the equivalent buffered response
+// once it completed. Responses without generated output, such as error bodies, are not stored.
+func (sc *SemanticCache) OnResponseBody(rc *RequestContext, body []byte, endOfStream bool) PhaseResult {
+	if !endOfStream || rc.prompt == "" || !rc.route.cacheEnabled() {
+		return PhaseResult{}
 	}
+	if rc.stream != nil {
+		if !rc.stream.done {
+			logger("semantic_cache").DebugContext(rc.Context(), "Event stream ended without completing, not caching")
+			return PhaseResult{}
+		}
+		body = rc.stream.completion()
+	}
+	if d := rc.guard.response; d.blocks() || d == guardFailClosed {
+		logger("semantic_cache").DebugContext(rc.Context(), "Response rejected by the prompt guard, not caching", "decision", d)
+		return PhaseResult{}
+	}
+	if rc.stream != nil && rc.stream.output().empty() || rc.stream == nil && extractResponseOutput(rc.response).empty() {
+		logger("semantic_cache").DebugContext(rc.Context(), "Response has no generated output, not caching")
+		return PhaseResult{}
+	}
+
+	if rc.control.noStore {
+		logger("semantic_cache").DebugContext(rc.Context(), "Cache storage disabled by request headers")
+	} else if sc.addEntry(rc.Context(), rc.scope, rc.prompt, rc.embedding, body, rc.cacheTTL(sc.ttl)) {
+		logger("semantic_cache").DebugContext(rc.Context(), "Added cache entry", "prompt", logging.Text(rc.prompt))
+	}
+	return PhaseResult{}
+}
+
+// Process runs the semantic cache as the only filter of a chain
+func (sc *SemanticCache) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
+	return NewChain(sc).Process(srv)
 }
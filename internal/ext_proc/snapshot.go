@@ -0,0 +1,305 @@
+package ext_proc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Snapshot layout, all integers little-endian:
+//
+//	magic      [8]byte "INFCACHE"
+//	version    uint16
+//	entries    uint32, followed by that many entry records
+//	embeddings uint32, followed by that many embedding records
+//	checksum   uint32, CRC-32C of every preceding byte
+//
+// entry:     id, scope, prompt, response (bytes), create time, expiry, vector
+// embedding: prompt (bytes), expiry, vector
+//
+// bytes are a uvarint length followed by the data, times are unix nanoseconds as int64
+// with 0 meaning unset, and vectors are a uint32 dimension followed by float64 values.
+const (
+	snapshotMagic   = "INFCACHE"
+	snapshotVersion = 1
+)
+
+var snapshotCRC = crc32.MakeTable(crc32.Castagnoli)
+
+type snapshotEntry struct {
+	Entry   *CacheEntry
+	Expires time.Time
+}
+
+type snapshotEmbedding struct {
+	Prompt    string
+	Embedding []float64
+	Expires   time.Time
+}
+
+type cacheSnapshot struct {
+	Entries    []snapshotEntry
+	Embeddings []snapshotEmbedding
+}
+
+// saveSnapshot writes the snapshot to a temporary file and renames it over path,
+// so readers never observe a partially written snapshot
+func saveSnapshot(path string, snap *cacheSnapshot) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create snapshot dir: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("create snapshot file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := writeSnapshot(w, snap); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close snapshot: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadSnapshot reads and verifies the snapshot at path
+func loadSnapshot(path string) (*cacheSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return readSnapshot(data)
+}
+
+func writeSnapshot(w io.Writer, snap *cacheSnapshot) error {
+	h := crc32.New(snapshotCRC)
+	sw := &snapshotWriter{w: io.MultiWriter(w, h)}
+
+	sw.raw([]byte(snapshotMagic))
+	sw.uint16(snapshotVersion)
+	sw.uint32(uint32(len(snap.Entries)))
+	for _, se := range snap.Entries {
+		e := se.Entry
+		sw.bytes([]byte(e.ID))
+		sw.bytes([]byte(e.Scope))
+		sw.bytes([]byte(e.Prompt))
+		sw.bytes(e.Response)
+		sw.time(e.CreateTime)
+		sw.time(se.Expires)
+		sw.vector(e.Embedding)
+	}
+	sw.uint32(uint32(len(snap.Embeddings)))
+	for _, se := range snap.Embeddings {
+		sw.bytes([]byte(se.Prompt))
+		sw.time(se.Expires)
+		sw.vector(se.Embedding)
+	}
+	if sw.err != nil {
+		return fmt.Errorf("write snapshot: %w", sw.err)
+	}
+	if err := binary.Write(w, binary.LittleEndian, h.Sum32()); err != nil {
+		return fmt.Errorf("write snapshot checksum: %w", err)
+	}
+	return nil
+}
+
+func readSnapshot(data []byte) (*cacheSnapshot, error) {
+	if len(data) < len(snapshotMagic)+2+4 {
+		return nil, errors.New("snapshot truncated")
+	}
+	if string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, errors.New("not a cache snapshot")
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.Checksum(body, snapshotCRC) != sum {
+		return nil, errors.New("snapshot checksum mismatch")
+	}
+
+	sr := &snapshotReader{r: bytes.NewReader(body[len(snapshotMagic):])}
+	if v := sr.uint16(); sr.err == nil && v != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", v)
+	}
+
+	snap := &cacheSnapshot{}
+	for n := sr.count(); n > 0 && sr.err == nil; n-- {
+		e := &CacheEntry{
+			ID:     string(sr.bytes()),
+			Scope:  string(sr.bytes()),
+			Prompt: string(sr.bytes()),
+		}
+		e.Response = sr.bytes()
+		e.CreateTime = sr.time()
+		expires := sr.time()
+		e.Embedding = sr.vector()
+		snap.Entries = append(snap.Entries, snapshotEntry{Entry: e, Expires: expires})
+	}
+	for n := sr.count(); n > 0 && sr.err == nil; n-- {
+		se := snapshotEmbedding{Prompt: string(sr.bytes())}
+		se.Expires = sr.time()
+		se.Embedding = sr.vector()
+		snap.Embeddings = append(snap.Embeddings, se)
+	}
+	if sr.err != nil {
+		return nil, fmt.Errorf("read snapshot: %w", sr.err)
+	}
+	if sr.r.Len() != 0 {
+		return nil, fmt.Errorf("read snapshot: %d trailing bytes", sr.r.Len())
+	}
+	return snap, nil
+}
+
+// snapshotWriter records the first error so encoding reads as a flat sequence of fields
+type snapshotWriter struct {
+	w   io.Writer
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (sw *snapshotWriter) raw(b []byte) {
+	if sw.err == nil {
+		_, sw.err = sw.w.Write(b)
+	}
+}
+
+func (sw *snapshotWriter) uint16(v uint16) {
+	binary.LittleEndian.PutUint16(sw.buf[:2], v)
+	sw.raw(sw.buf[:2])
+}
+
+func (sw *snapshotWriter) uint32(v uint32) {
+	binary.LittleEndian.PutUint32(sw.buf[:4], v)
+	sw.raw(sw.buf[:4])
+}
+
+func (sw *snapshotWriter) uint64(v uint64) {
+	binary.LittleEndian.PutUint64(sw.buf[:8], v)
+	sw.raw(sw.buf[:8])
+}
+
+func (sw *snapshotWriter) bytes(b []byte) {
+	n := binary.PutUvarint(sw.buf[:], uint64(len(b)))
+	sw.raw(sw.buf[:n])
+	sw.raw(b)
+}
+
+func (sw *snapshotWriter) time(t time.Time) {
+	var ns int64
+	if !t.IsZero() {
+		ns = t.UnixNano()
+	}
+	sw.uint64(uint64(ns))
+}
+
+func (sw *snapshotWriter) vector(v []float64) {
+	sw.uint32(uint32(len(v)))
+	for _, x := range v {
+		sw.uint64(math.Float64bits(x))
+	}
+}
+
+// snapshotReader records the first error, later reads return zero values
+type snapshotReader struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (sr *snapshotReader) fixed(n int) []byte {
+	if sr.err != nil {
+		return nil
+	}
+	if sr.r.Len() < n {
+		sr.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := make([]byte, n)
+	_, _ = sr.r.Read(b)
+	return b
+}
+
+func (sr *snapshotReader) uint16() uint16 {
+	if b := sr.fixed(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (sr *snapshotReader) uint32() uint32 {
+	if b := sr.fixed(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (sr *snapshotReader) uint64() uint64 {
+	if b := sr.fixed(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// count reads a record count, rejecting values that cannot fit in the remaining data
+func (sr *snapshotReader) count() int {
+	n := int(sr.uint32())
+	if sr.err == nil && n > sr.r.Len() {
+		sr.err = fmt.Errorf("record count %d exceeds snapshot size", n)
+		return 0
+	}
+	return n
+}
+
+func (sr *snapshotReader) bytes() []byte {
+	if sr.err != nil {
+		return nil
+	}
+	n, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		sr.err = err
+		return nil
+	}
+	if n > uint64(sr.r.Len()) {
+		sr.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	return sr.fixed(int(n))
+}
+
+func (sr *snapshotReader) time() time.Time {
+	ns := int64(sr.uint64())
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+func (sr *snapshotReader) vector() []float64 {
+	dim := int(sr.uint32())
+	if sr.err == nil && dim*8 > sr.r.Len() {
+		sr.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	v := make([]float64, dim)
+	for i := range v {
+		v[i] = math.Float64frombits(sr.uint64())
+	}
+	return v
+}
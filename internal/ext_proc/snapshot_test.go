@@ -0,0 +1,114 @@
+package ext_proc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Snapshot", func() {
+	created := time.Unix(1700000000, 0)
+	expires := time.Unix(1800000000, 0)
+
+	encode := func(snap *cacheSnapshot) []byte {
+		var buf bytes.Buffer
+		Expect(writeSnapshot(&buf, snap)).To(Succeed())
+		return buf.Bytes()
+	}
+
+	sample := &cacheSnapshot{
+		Entries: []snapshotEntry{{
+			Entry: &CacheEntry{
+				ID:         "abc",
+				Scope:      "model=gpt-4.1",
+				Prompt:     "What is Kubernetes?",
+				Embedding:  []float64{0.1, -0.2, 0.3},
+				Response:   []byte(`{"choices":[]}`),
+				CreateTime: created,
+			},
+			Expires: expires,
+		}},
+		Embeddings: []snapshotEmbedding{{Prompt: "What is Kubernetes?", Embedding: []float64{0.1, -0.2, 0.3}}},
+	}
+
+	It("should round trip entries and embeddings", func() {
+		snap, err := readSnapshot(encode(sample))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(snap.Entries).To(HaveLen(1))
+		e := snap.Entries[0].Entry
+		Expect(e.ID).To(Equal("abc"))
+		Expect(e.Scope).To(Equal("model=gpt-4.1"))
+		Expect(e.Prompt).To(Equal("What is Kubernetes?"))
+		Expect(e.Embedding).To(Equal([]float64{0.1, -0.2, 0.3}))
+		Expect(string(e.Response)).To(Equal(`{"choices":[]}`))
+		Expect(e.CreateTime.Equal(created)).To(BeTrue())
+		Expect(snap.Entries[0].Expires.Equal(expires)).To(BeTrue())
+		Expect(snap.Embeddings).To(HaveLen(1))
+		Expect(snap.Embeddings[0].Expires.IsZero()).To(BeTrue())
+	})
+
+	It("should reject a corrupted snapshot", func() {
+		data := encode(sample)
+		data[20] ^= 0xff
+		_, err := readSnapshot(data)
+		Expect(err).To(MatchError(ContainSubstring("checksum")))
+	})
+
+	It("should reject a truncated snapshot", func() {
+		data := encode(sample)
+		_, err := readSnapshot(data[:len(data)-10])
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject an unknown version", func() {
+		data := encode(&cacheSnapshot{})
+		binary.LittleEndian.PutUint16(data[len(snapshotMagic):], snapshotVersion+1)
+		body := data[:len(data)-4]
+		binary.LittleEndian.PutUint32(data[len(data)-4:], crc32.Checksum(body, snapshotCRC))
+		_, err := readSnapshot(data)
+		Expect(err).To(MatchError(ContainSubstring("unsupported snapshot version")))
+	})
+
+	Context("with SEMANTIC_CACHE_SNAPSHOT_PATH set", func() {
+		var path string
+
+		BeforeEach(func() {
+			path = filepath.Join(GinkgoT().TempDir(), "cache.snap")
+			GinkgoT().Setenv("SEMANTIC_CACHE_SNAPSHOT_PATH", path)
+			GinkgoT().Setenv("SEMANTIC_CACHE_STORE", "memory")
+			GinkgoT().Setenv("SEMANTIC_CACHE_INDEX", "flat")
+		})
+
+		It("should restore the cache written on close", func() {
+			sc := NewSemanticCache()
+			sc.embeddingCache.Set("What is Kubernetes?", []float64{1, 0}, 0)
+			Expect(sc.addEntry(context.Background(), "tenant-a", "What is Kubernetes?", []float64{1, 0}, []byte("cached"), 0)).To(BeTrue())
+			sc.Close()
+
+			restarted := NewSemanticCache()
+			defer restarted.Close()
+			e, sim := restarted.findMostSimilarPrompt(context.Background(), "tenant-a", []float64{1, 0})
+			Expect(e).NotTo(BeNil())
+			Expect(string(e.Response)).To(Equal("cached"))
+			Expect(sim).To(BeNumerically("~", 1.0, 1e-9))
+			_, ok := restarted.embeddingCache.Get("What is Kubernetes?")
+			Expect(ok).To(BeTrue())
+		})
+
+		It("should start empty when the snapshot is corrupt", func() {
+			Expect(os.WriteFile(path, []byte("INFCACHE garbage"), 0o644)).To(Succeed())
+
+			sc := NewSemanticCache()
+			defer sc.Close()
+			e, _ := sc.findMostSimilarPrompt(context.Background(), "", []float64{1, 0})
+			Expect(e).To(BeNil())
+		})
+	})
+})
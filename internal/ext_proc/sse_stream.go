@@ -0,0 +1,343 @@
+package ext_proc
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"strings"
+	"time"
+)
+
+// isEventStream reports whether the response content type is text/event-stream
+func isEventStream(headers map[string]string) bool {
+	mediaType, _, err := mime.ParseMediaType(headers["content-type"])
+	return err == nil && mediaType == "text/event-stream"
+}
+
+// tokenUsage is the token accounting reported by the model server
+type tokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// responsesUsage is the token accounting of the Responses API
+type responsesUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// streamEvent holds the fields we use from chat.completion.chunk, text_completion and Responses API stream events
+type streamEvent struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Index int    `json:"index"`
+		Text  string `json:"text"`
+		Delta struct {
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *tokenUsage `json:"usage"`
+
+	// Responses API events carry their type and, for text deltas, the delta as a string
+	Type     string                 `json:"type"`
+	Delta    string                 `json:"delta"`
+	Item     map[string]interface{} `json:"item"`
+	Response *struct {
+		ID        string          `json:"id"`
+		Model     string          `json:"model"`
+		CreatedAt int64           `json:"created_at"`
+		Usage     *responsesUsage `json:"usage"`
+	} `json:"response"`
+}
+
+// sseAccumulator parses a server-sent event stream as it arrives, body chunks may split lines and events anywhere.
+// It collects the generated text and tool calls of every choice and the usage reported by the final chunk, which
+// OpenAI only sends when the request sets stream_options.include_usage.
+type sseAccumulator struct {
+	partial []byte
+	data    []string
+
+	// choices is indexed by choice index, Responses API streams have a single output
+	choices   []*streamChoice
+	usage     *tokenUsage
+	id        string
+	object    string
+	model     string
+	created   int64
+	responses bool
+	done      bool
+}
+
+// streamChoice is the output of one choice of a stream
+type streamChoice struct {
+	text strings.Builder
+	// toolCalls is indexed by the index of the tool call deltas, whose arguments arrive in fragments
+	toolCalls    []ToolCall
+	finishReason string
+}
+
+// choice returns the choice at index, adding the choices up to it
+func (a *sseAccumulator) choice(index int) *streamChoice {
+	for len(a.choices) <= index {
+		a.choices = append(a.choices, &streamChoice{})
+	}
+	return a.choices[index]
+}
+
+// Write feeds the next chunk of the response body
+func (a *sseAccumulator) Write(chunk []byte) {
+	a.partial = append(a.partial, chunk...)
+	for {
+		i := bytes.IndexByte(a.partial, '\n')
+		if i < 0 {
+			return
+		}
+		a.line(string(bytes.TrimSuffix(a.partial[:i], []byte("\r"))))
+		a.partial = a.partial[i+1:]
+	}
+}
+
+// Close dispatches an event left unterminated at the end of the stream
+func (a *sseAccumulator) Close() {
+	if len(a.partial) > 0 {
+		a.line(string(bytes.TrimSuffix(a.partial, []byte("\r"))))
+		a.partial = nil
+	}
+	a.line("")
+}
+
+func (a *sseAccumulator) line(l string) {
+	if l == "" {
+		if len(a.data) > 0 {
+			a.event(strings.Join(a.data, "\n"))
+			a.data = a.data[:0]
+		}
+		return
+	}
+	// event, id, retry fields and comments are not needed, Responses API events repeat their type in the data
+	if v, ok := strings.CutPrefix(l, "data:"); ok {
+		a.data = append(a.data, strings.TrimPrefix(v, " "))
+	}
+}
+
+func (a *sseAccumulator) event(data string) {
+	if data == "[DONE]" {
+		a.done = true
+		return
+	}
+	var ev streamEvent
+	if err := json.Unmarshal([]byte(data), &ev); err != nil {
+		logger("stream").Debug("Skipping unparsable event", "error", err)
+		return
+	}
+
+	if strings.HasPrefix(ev.Type, "response.") {
+		a.responses = true
+		switch ev.Type {
+		case "response.output_text.delta":
+			a.choice(0).text.WriteString(ev.Delta)
+		case "response.output_item.done":
+			if ev.Item["type"] == "function_call" {
+				tc := functionCall(ev.Item)
+				tc.ID, _ = ev.Item["call_id"].(string)
+				c := a.choice(0)
+				c.toolCalls = append(c.toolCalls, tc)
+			}
+		case "response.created", "response.completed":
+			if ev.Response != nil {
+				a.id, a.model, a.created = ev.Response.ID, ev.Response.Model, ev.Response.CreatedAt
+				if u := ev.Response.Usage; u != nil {
+					a.usage = &tokenUsage{PromptTokens: u.InputTokens, CompletionTokens: u.OutputTokens, TotalTokens: u.TotalTokens}
+				}
+			}
+			if ev.Type == "response.completed" {
+				a.done = true
+			}
+		}
+		return
+	}
+
+	if a.id == "" {
+		a.id, a.object, a.model, a.created = ev.ID, ev.Object, ev.Model, ev.Created
+	}
+	for _, ec := range ev.Choices {
+		if ec.Index < 0 {
+			continue
+		}
+		c := a.choice(ec.Index)
+		c.text.WriteString(ec.Text)
+		c.text.WriteString(ec.Delta.Content)
+		for _, tc := range ec.Delta.ToolCalls {
+			if tc.Index < 0 {
+				continue
+			}
+			for len(c.toolCalls) <= tc.Index {
+				c.toolCalls = append(c.toolCalls, ToolCall{})
+			}
+			call := &c.toolCalls[tc.Index]
+			if tc.ID != "" {
+				call.ID = tc.ID
+			}
+			if tc.Function.Name != "" {
+				call.Name = tc.Function.Name
+			}
+			call.Arguments += tc.Function.Arguments
+		}
+		if ec.FinishReason != nil {
+			c.finishReason = *ec.FinishReason
+		}
+	}
+	if ev.Usage != nil {
+		a.usage = ev.Usage
+	}
+}
+
+// Text returns the text generated so far, the texts of several choices are joined by newlines
+func (a *sseAccumulator) Text() string {
+	var texts []string
+	for _, c := range a.choices {
+		if t := c.text.String(); t != "" {
+			texts = append(texts, t)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// output returns the text and tool calls generated so far by every choice
+func (a *sseAccumulator) output() responseOutput {
+	out := responseOutput{Text: a.Text()}
+	for _, c := range a.choices {
+		out.ToolCalls = append(out.ToolCalls, c.toolCalls...)
+	}
+	return out
+}
+
+// size returns how many bytes of text and tool call arguments were generated so far
+func (a *sseAccumulator) size() int {
+	n := 0
+	for _, c := range a.choices {
+		n += c.text.Len()
+		for _, tc := range c.toolCalls {
+			n += len(tc.Name) + len(tc.Arguments)
+		}
+	}
+	return n
+}
+
+// completion synthesizes the non-streaming response equivalent to the stream, so it can be cached
+// and served like any buffered response
+func (a *sseAccumulator) completion() []byte {
+	var out map[string]interface{}
+	created := a.created
+	if created == 0 {
+		created = time.Now().Unix()
+	}
+
+	// a stream without output still completes with an empty choice
+	choices := a.choices
+	if len(choices) == 0 {
+		choices = []*streamChoice{{}}
+	}
+
+	switch {
+	case a.responses:
+		c := choices[0]
+		var output []interface{}
+		if c.text.Len() > 0 || len(c.toolCalls) == 0 {
+			output = append(output, map[string]interface{}{
+				"type":    "message",
+				"role":    "assistant",
+				"status":  "completed",
+				"content": []interface{}{map[string]interface{}{"type": "output_text", "text": c.text.String(), "annotations": []interface{}{}}},
+			})
+		}
+		for _, tc := range c.toolCalls {
+			output = append(output, map[string]interface{}{
+				"type":      "function_call",
+				"call_id":   tc.ID,
+				"name":      tc.Name,
+				"arguments": tc.Arguments,
+				"status":    "completed",
+			})
+		}
+		out = map[string]interface{}{
+			"id":         a.id,
+			"object":     "response",
+			"created_at": created,
+			"model":      a.model,
+			"status":     "completed",
+			"output":     output,
+		}
+		if a.usage != nil {
+			out["usage"] = responsesUsage{InputTokens: a.usage.PromptTokens, OutputTokens: a.usage.CompletionTokens, TotalTokens: a.usage.TotalTokens}
+		}
+	case a.object == "text_completion":
+		out = map[string]interface{}{
+			"id":      a.id,
+			"object":  "text_completion",
+			"created": created,
+			"model":   a.model,
+		}
+		var cs []interface{}
+		for i, c := range choices {
+			cs = append(cs, map[string]interface{}{"index": i, "text": c.text.String(), "finish_reason": c.finishReason})
+		}
+		out["choices"] = cs
+	default:
+		var cs []interface{}
+		for i, c := range choices {
+			message := map[string]interface{}{"role": "assistant", "content": c.text.String()}
+			if len(c.toolCalls) > 0 {
+				calls := make([]interface{}, len(c.toolCalls))
+				for j, tc := range c.toolCalls {
+					calls[j] = map[string]interface{}{
+						"id":       tc.ID,
+						"type":     "function",
+						"function": map[string]interface{}{"name": tc.Name, "arguments": tc.Arguments},
+					}
+				}
+				message["tool_calls"] = calls
+				if c.text.Len() == 0 {
+					message["content"] = nil
+				}
+			}
+			cs = append(cs, map[string]interface{}{"index": i, "message": message, "finish_reason": c.finishReason})
+		}
+		out = map[string]interface{}{
+			"id":      a.id,
+			"object":  "chat.completion",
+			"created": created,
+			"model":   a.model,
+			"choices": cs,
+		}
+	}
+	if a.usage != nil && !a.responses {
+		out["usage"] = a.usage
+	}
+
+	b, _ := json.Marshal(out)
+	return b
+}
+
+// streamBlockedEvent replaces the end of a stream whose output was blocked, the client has already
+// received the earlier chunks so the best we can do is terminate with an error event
+func streamBlockedEvent(message string) []byte {
+	b, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{"message": message, "type": "content_filter"},
+	})
+	return []byte("data: " + string(b) + "\n\ndata: [DONE]\n\n")
+}
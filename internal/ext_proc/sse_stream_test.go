@@ -0,0 +1,139 @@
+package ext_proc
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("sseAccumulator", func() {
+	const chatStream = "data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"created\":1700000000,\"model\":\"gpt-4.1\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"\"}}]}\n\n" +
+		"data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"A container \"}}]}\n\n" +
+		"data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"orchestrator.\"},\"finish_reason\":\"stop\"}]}\n\n" +
+		"data: {\"id\":\"chatcmpl-1\",\"object\":\"chat.completion.chunk\",\"choices\":[],\"usage\":{\"prompt_tokens\":5,\"completion_tokens\":4,\"total_tokens\":9}}\n\n" +
+		"data: [DONE]\n\n"
+
+	feed := func(stream string, chunkSize int) *sseAccumulator {
+		a := &sseAccumulator{}
+		for i := 0; i < len(stream); i += chunkSize {
+			a.Write([]byte(stream[i:min(i+chunkSize, len(stream))]))
+		}
+		a.Close()
+		return a
+	}
+
+	It("should accumulate chat deltas and the usage chunk", func() {
+		a := feed(chatStream, len(chatStream))
+		Expect(a.Text()).To(Equal("A container orchestrator."))
+		Expect(a.usage).To(Equal(&tokenUsage{PromptTokens: 5, CompletionTokens: 4, TotalTokens: 9}))
+		Expect(a.done).To(BeTrue())
+	})
+
+	It("should handle chunks split mid line", func() {
+		for _, size := range []int{1, 7, 64} {
+			a := feed(chatStream, size)
+			Expect(a.Text()).To(Equal("A container orchestrator."))
+			Expect(a.usage).NotTo(BeNil())
+		}
+	})
+
+	It("should handle CRLF line endings and multi line data", func() {
+		a := feed("data: {\"object\":\"text_completion\",\r\ndata: \"choices\":[{\"index\":0,\"text\":\"hi\"}]}\r\n\r\n", 5)
+		Expect(a.Text()).To(Equal("hi"))
+	})
+
+	It("should dispatch an unterminated final event", func() {
+		a := feed(`data: {"choices":[{"index":0,"text":"tail"}]}`, 10)
+		Expect(a.Text()).To(Equal("tail"))
+	})
+
+	It("should accumulate every choice", func() {
+		a := feed("data: {\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"a\"}},{\"index\":1,\"delta\":{\"content\":\"b\"}}]}\n\n"+
+			"data: {\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":1,\"delta\":{\"content\":\"c\"},\"finish_reason\":\"stop\"}]}\n\n", 100)
+		Expect(a.Text()).To(Equal("a\nbc"))
+
+		var body map[string]interface{}
+		Expect(json.Unmarshal(a.completion(), &body)).To(Succeed())
+		Expect(body["choices"]).To(HaveLen(2))
+		Expect(extractResponseOutput(body).Text).To(Equal("a\nbc"))
+	})
+
+	It("should synthesize a chat completion", func() {
+		var out struct {
+			Object  string
+			Model   string
+			Choices []struct {
+				Message      struct{ Role, Content string }
+				FinishReason string `json:"finish_reason"`
+			}
+			Usage tokenUsage
+		}
+		Expect(json.Unmarshal(feed(chatStream, 100).completion(), &out)).To(Succeed())
+		Expect(out.Object).To(Equal("chat.completion"))
+		Expect(out.Model).To(Equal("gpt-4.1"))
+		Expect(out.Choices[0].Message.Content).To(Equal("A container orchestrator."))
+		Expect(out.Choices[0].FinishReason).To(Equal("stop"))
+		Expect(out.Usage.TotalTokens).To(Equal(9))
+	})
+
+	It("should accumulate Responses API events", func() {
+		stream := "event: response.created\ndata: {\"type\":\"response.created\",\"response\":{\"id\":\"resp_1\",\"model\":\"gpt-4.1\"}}\n\n" +
+			"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"Once \"}\n\n" +
+			"event: response.output_text.delta\ndata: {\"type\":\"response.output_text.delta\",\"delta\":\"upon a time\"}\n\n" +
+			"event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{\"id\":\"resp_1\",\"model\":\"gpt-4.1\",\"usage\":{\"input_tokens\":3,\"output_tokens\":4,\"total_tokens\":7}}}\n\n"
+		a := feed(stream, 13)
+		Expect(a.Text()).To(Equal("Once upon a time"))
+		Expect(a.usage).To(Equal(&tokenUsage{PromptTokens: 3, CompletionTokens: 4, TotalTokens: 7}))
+		Expect(a.done).To(BeTrue())
+
+		var out struct {
+			Object string
+			Output []struct {
+				Content []struct{ Text string }
+			}
+			Usage responsesUsage
+		}
+		Expect(json.Unmarshal(a.completion(), &out)).To(Succeed())
+		Expect(out.Object).To(Equal("response"))
+		Expect(out.Output[0].Content[0].Text).To(Equal("Once upon a time"))
+		Expect(out.Usage.OutputTokens).To(Equal(4))
+	})
+
+	It("should collect tool call fragments by index", func() {
+		stream := "data: {\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":null,\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"type\":\"function\",\"function\":{\"name\":\"get_weather\",\"arguments\":\"\"}}]}}]}\n\n" +
+			"data: {\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"{\\\"city\\\":\"}}]}}]}\n\n" +
+			"data: {\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":1,\"id\":\"call_2\",\"function\":{\"name\":\"get_time\",\"arguments\":\"{}\"}}]}}]}\n\n" +
+			"data: {\"object\":\"chat.completion.chunk\",\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"\\\"Paris\\\"}\"}}]},\"finish_reason\":\"tool_calls\"}]}\n\n" +
+			"data: [DONE]\n\n"
+		a := feed(stream, 17)
+		calls := []ToolCall{{ID: "call_1", Name: "get_weather", Arguments: `{"city":"Paris"}`}, {ID: "call_2", Name: "get_time", Arguments: "{}"}}
+		Expect(a.Text()).To(BeEmpty())
+		Expect(a.output()).To(Equal(responseOutput{ToolCalls: calls}))
+
+		var body map[string]interface{}
+		Expect(json.Unmarshal(a.completion(), &body)).To(Succeed())
+		Expect(extractResponseOutput(body)).To(Equal(responseOutput{ToolCalls: calls}))
+		Expect(body["choices"].([]interface{})[0].(map[string]interface{})["finish_reason"]).To(Equal("tool_calls"))
+	})
+
+	It("should collect Responses API function calls", func() {
+		stream := "data: {\"type\":\"response.function_call_arguments.delta\",\"delta\":\"{}\"}\n\n" +
+			"data: {\"type\":\"response.output_item.done\",\"item\":{\"type\":\"function_call\",\"call_id\":\"call_1\",\"name\":\"get_time\",\"arguments\":\"{}\"}}\n\n" +
+			"data: {\"type\":\"response.completed\",\"response\":{\"id\":\"resp_1\"}}\n\n"
+		a := feed(stream, 100)
+		calls := []ToolCall{{ID: "call_1", Name: "get_time", Arguments: "{}"}}
+		Expect(a.output()).To(Equal(responseOutput{ToolCalls: calls}))
+
+		var body map[string]interface{}
+		Expect(json.Unmarshal(a.completion(), &body)).To(Succeed())
+		Expect(body["output"]).To(HaveLen(1))
+		Expect(extractResponseOutput(body)).To(Equal(responseOutput{ToolCalls: calls}))
+	})
+
+	It("should detect event stream content types", func() {
+		Expect(isEventStream(map[string]string{"content-type": "text/event-stream; charset=utf-8"})).To(BeTrue())
+		Expect(isEventStream(map[string]string{"content-type": "application/json"})).To(BeFalse())
+		Expect(isEventStream(nil)).To(BeFalse())
+	})
+})
@@ -2,19 +2,17 @@ package ext_proc
 
 import (
 	"encoding/json"
-	"io"
-	"log"
 	"strconv"
 
 	configPb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
-	filterPb "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/ext_proc/v3"
 	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/kuadrant/inferno/internal/metrics"
 )
 
 type TokenUsageMetrics struct {
+	BaseFilter
 }
 
 func NewTokenUsageMetrics() *TokenUsageMetrics {
@@ -44,10 +42,11 @@ func ExtractTokenMetricsHeaders(responseBody []byte) []*configPb.HeaderValueOpti
 // extracts token usage metrics from the response body and returns appropriate headers
 // returns a processing response with the token usage headers and a boolean indicating if metrics were found
 func (tm *TokenUsageMetrics) ProcessResponseBody(body []byte) (*extProcPb.ProcessingResponse, bool) {
-	log.Println("[TokenMetrics] Processing response body")
+	log := logger("token_metrics")
+	log.Debug("Processing response body")
 
 	if !json.Valid(body) {
-		log.Printf("[TokenMetrics] Response body is not valid JSON")
+		log.Debug("Response body is not valid JSON")
 		return &extProcPb.ProcessingResponse{
 			Response: &extProcPb.ProcessingResponse_ResponseBody{
 				ResponseBody: &extProcPb.BodyResponse{},
@@ -58,7 +57,7 @@ func (tm *TokenUsageMetrics) ProcessResponseBody(body []byte) (*extProcPb.Proces
 	// try to unmarshal into a map to check for usage field existence
 	var responseMap map[string]interface{}
 	if err := json.Unmarshal(body, &responseMap); err != nil {
-		log.Printf("[TokenMetrics] Failed to unmarshal JSON: %v", err)
+		log.Debug("Failed to unmarshal JSON", "error", err)
 		return &extProcPb.ProcessingResponse{
 			Response: &extProcPb.ProcessingResponse_ResponseBody{
 				ResponseBody: &extProcPb.BodyResponse{},
@@ -69,7 +68,7 @@ func (tm *TokenUsageMetrics) ProcessResponseBody(body []byte) (*extProcPb.Proces
 	// usage field existence
 	_, exists := responseMap["usage"]
 	if !exists {
-		log.Printf("[TokenMetrics] No 'usage' field found in response")
+		log.Debug("No usage field found in response")
 		return &extProcPb.ProcessingResponse{
 			Response: &extProcPb.ProcessingResponse_ResponseBody{
 				ResponseBody: &extProcPb.BodyResponse{},
@@ -88,7 +87,7 @@ func (tm *TokenUsageMetrics) ProcessResponseBody(body []byte) (*extProcPb.Proces
 
 	err := json.Unmarshal(body, &openAIResp)
 	if err != nil {
-		log.Printf("[TokenMetrics] Failed to unmarshal JSON for token metrics: %v", err)
+		log.Debug("Failed to unmarshal JSON for token metrics", "error", err)
 		return &extProcPb.ProcessingResponse{
 			Response: &extProcPb.ProcessingResponse_ResponseBody{
 				ResponseBody: &extProcPb.BodyResponse{},
@@ -138,88 +137,74 @@ func (tm *TokenUsageMetrics) ProcessResponseBody(body []byte) (*extProcPb.Proces
 		},
 	}
 
-	log.Printf("[TokenMetrics] Added token headers: prompt=%s, completion=%s, total=%s",
-		promptTokens, completionTokens, totalTokens)
+	log.Debug("Added token headers", "prompt", promptTokens, "completion", completionTokens, "total", totalTokens)
 	return resp, true
 }
 
-func (tm *TokenUsageMetrics) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
-	log.Println("[TokenMetrics] Starting processing loop")
-	for {
-		req, err := srv.Recv()
-		if err == io.EOF {
-			log.Println("[TokenMetrics] Received EOF, terminating processing loop")
-			return nil
-		}
-		if err != nil {
-			log.Printf("[TokenMetrics] Error receiving request: %v", err)
-			return status.Errorf(codes.Unknown, "cannot receive stream request: %v", err)
-		}
-
-		log.Printf("[TokenMetrics] Received request: %+v", req)
-
-		var resp *extProcPb.ProcessingResponse
+func (tm *TokenUsageMetrics) Name() string {
+	return "token-metrics"
+}
 
-		switch r := req.Request.(type) {
-		case *extProcPb.ProcessingRequest_RequestHeaders:
-			// pass through headers untouched
-			resp = &extProcPb.ProcessingResponse{
-				Response: &extProcPb.ProcessingResponse_RequestHeaders{
-					RequestHeaders: &extProcPb.HeadersResponse{},
-				},
-			}
+// OnResponseBody adds the token usage headers of a buffered response
+func (tm *TokenUsageMetrics) OnResponseBody(rc *RequestContext, body []byte, endOfStream bool) PhaseResult {
+	if rc.stream != nil || !endOfStream || !rc.route.tokenMetrics() {
+		return PhaseResult{}
+	}
+	var resp struct {
+		Model string      `json:"model"`
+		Usage *tokenUsage `json:"usage"`
+	}
+	if json.Unmarshal(body, &resp) == nil && resp.Usage != nil {
+		recordTokens(rc, resp.Model, *resp.Usage)
+	}
+	return PhaseResult{SetHeaders: ExtractTokenMetricsHeaders(body)}
+}
 
-		case *extProcPb.ProcessingRequest_RequestBody:
-			// pass body untouched
-			resp = &extProcPb.ProcessingResponse{
-				Response: &extProcPb.ProcessingResponse_RequestBody{
-					RequestBody: &extProcPb.BodyResponse{},
-				},
-			}
+// OnResponseTrailers adds the token usage of an event stream, reported by its final chunk
+func (tm *TokenUsageMetrics) OnResponseTrailers(rc *RequestContext) PhaseResult {
+	if rc.stream == nil || rc.stream.usage == nil || !rc.route.tokenMetrics() {
+		return PhaseResult{}
+	}
+	recordTokens(rc, rc.stream.model, *rc.stream.usage)
+	return PhaseResult{SetHeaders: tokenUsageHeaders(*rc.stream.usage)}
+}
 
-		case *extProcPb.ProcessingRequest_ResponseHeaders:
-			// buffer the response body
-			resp = &extProcPb.ProcessingResponse{
-				Response: &extProcPb.ProcessingResponse_ResponseHeaders{
-					ResponseHeaders: &extProcPb.HeadersResponse{},
-				},
-				ModeOverride: &filterPb.ProcessingMode{
-					ResponseHeaderMode: filterPb.ProcessingMode_SKIP,
-					ResponseBodyMode:   filterPb.ProcessingMode_BUFFERED,
-				},
-			}
-
-		case *extProcPb.ProcessingRequest_ResponseBody:
-			rb := r.ResponseBody
-			if !rb.EndOfStream {
-				resp = &extProcPb.ProcessingResponse{
-					Response: &extProcPb.ProcessingResponse_ResponseBody{
-						ResponseBody: &extProcPb.BodyResponse{},
-					},
-				}
-				break
-			}
-
-			// Use the shared method to process the response body
-			var metricsFound bool
-			resp, metricsFound = tm.ProcessResponseBody(rb.Body)
-
-			if !metricsFound {
-				resp = &extProcPb.ProcessingResponse{
-					Response: &extProcPb.ProcessingResponse_ResponseBody{
-						ResponseBody: &extProcPb.BodyResponse{},
-					},
-				}
-			}
+// recordTokens counts upstream token usage by the requested model, or the model that answered when
+// the request didn't name one
+func recordTokens(rc *RequestContext, responseModel string, u tokenUsage) {
+	model := rc.model
+	if model == "" {
+		model = responseModel
+	}
+	metrics.Tokens.WithLabelValues(model, rc.route.Name, "prompt").Add(float64(u.PromptTokens))
+	metrics.Tokens.WithLabelValues(model, rc.route.Name, "completion").Add(float64(u.CompletionTokens))
+	logger("token_metrics").DebugContext(rc.Context(), "Recorded token usage", "model", model, "prompt_tokens", u.PromptTokens, "completion_tokens", u.CompletionTokens)
+}
 
-		default:
-			log.Printf("[TokenMetrics] Received unrecognized request type: %T", r)
-			resp = &extProcPb.ProcessingResponse{}
-		}
+// Process runs token usage metrics as the only filter of a chain
+func (tm *TokenUsageMetrics) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
+	return NewChain(tm).Process(srv)
+}
 
-		if err := srv.Send(resp); err != nil {
-			log.Printf("[TokenMetrics] Error sending response: %v", err)
-			return err
-		}
+// tokenUsageHeaders returns the token usage headers for usage reported outside a buffered body,
+// e.g. by the final chunk of a streamed response
+func tokenUsageHeaders(u tokenUsage) []*configPb.HeaderValueOption {
+	headers := make([]*configPb.HeaderValueOption, 0, 3)
+	for _, h := range []struct {
+		key   string
+		value int
+	}{
+		{"x-kuadrant-openai-prompt-tokens", u.PromptTokens},
+		{"x-kuadrant-openai-total-tokens", u.TotalTokens},
+		{"x-kuadrant-openai-completion-tokens", u.CompletionTokens},
+	} {
+		headers = append(headers, &configPb.HeaderValueOption{
+			Header: &configPb.HeaderValue{
+				Key:   h.key,
+				Value: strconv.Itoa(h.value),
+			},
+			Append: wrapperspb.Bool(false),
+		})
 	}
+	return headers
 }
@@ -0,0 +1,104 @@
+package ext_proc
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// VectorIndex finds the cache entry whose embedding is most similar to a query vector
+type VectorIndex interface {
+	// Add indexes the entry under its embedding, it fails when the index cannot compare it with its entries
+	Add(e *CacheEntry) error
+	// Remove drops the entry from the index, it is a no-op for unknown entries
+	Remove(e *CacheEntry)
+	// Search returns the nearest entry and its cosine similarity, or nil if the index is empty
+	Search(vec []float64) (*CacheEntry, float64)
+	// Len returns the number of indexed entries
+	Len() int
+}
+
+// NewVectorIndex returns the index implementation registered under kind,
+// falling back to HNSW for unknown or empty values
+func NewVectorIndex(kind string) VectorIndex {
+	switch kind {
+	case "flat":
+		return NewFlatIndex()
+	default:
+		return NewHNSWIndex(DefaultHNSWConfig())
+	}
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// FlatIndex is the brute-force reference implementation, it compares the query
+// against every entry and is exact but O(n) per lookup
+type FlatIndex struct {
+	mu      sync.RWMutex
+	entries []*CacheEntry
+	dim     int
+}
+
+func NewFlatIndex() *FlatIndex {
+	return &FlatIndex{}
+}
+
+// Add rejects embeddings whose dimension differs from the entries already indexed
+func (f *FlatIndex) Add(e *CacheEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.entries) == 0 {
+		f.dim = len(e.Embedding)
+	} else if len(e.Embedding) != f.dim {
+		return fmt.Errorf("embedding has %d dimensions, the index holds %d", len(e.Embedding), f.dim)
+	}
+	f.entries = append(f.entries, e)
+	return nil
+}
+
+func (f *FlatIndex) Remove(e *CacheEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, cur := range f.entries {
+		if cur == e {
+			last := len(f.entries) - 1
+			f.entries[i] = f.entries[last]
+			f.entries[last] = nil
+			f.entries = f.entries[:last]
+			return
+		}
+	}
+}
+
+func (f *FlatIndex) Search(vec []float64) (*CacheEntry, float64) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var best *CacheEntry
+	var bestSim float64
+	for _, e := range f.entries {
+		if s := cosineSimilarity(vec, e.Embedding); s > bestSim {
+			bestSim, best = s, e
+		}
+	}
+	return best, bestSim
+}
+
+func (f *FlatIndex) Len() int {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return len(f.entries)
+}
@@ -0,0 +1,125 @@
+package ext_proc_test
+
+import (
+	"math/rand"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/kuadrant/inferno/internal/ext_proc"
+)
+
+func randomEntries(rng *rand.Rand, n, dim int) []*ext_proc.CacheEntry {
+	entries := make([]*ext_proc.CacheEntry, n)
+	for i := range entries {
+		vec := make([]float64, dim)
+		for j := range vec {
+			vec[j] = rng.NormFloat64()
+		}
+		entries[i] = &ext_proc.CacheEntry{Embedding: vec}
+	}
+	return entries
+}
+
+var _ = Describe("VectorIndex", func() {
+	for _, kind := range []string{"flat", "hnsw"} {
+		Context("with the "+kind+" index", func() {
+			var idx ext_proc.VectorIndex
+
+			BeforeEach(func() {
+				idx = ext_proc.NewVectorIndex(kind)
+			})
+
+			It("should return nothing when empty", func() {
+				e, sim := idx.Search([]float64{1, 0, 0})
+				Expect(e).To(BeNil())
+				Expect(sim).To(BeZero())
+			})
+
+			It("should find an exact match", func() {
+				a := &ext_proc.CacheEntry{Prompt: "a", Embedding: []float64{1, 0, 0}}
+				b := &ext_proc.CacheEntry{Prompt: "b", Embedding: []float64{0, 1, 0}}
+				Expect(idx.Add(a)).To(Succeed())
+				Expect(idx.Add(b)).To(Succeed())
+
+				e, sim := idx.Search([]float64{0, 2, 0})
+				Expect(e).To(Equal(b))
+				Expect(sim).To(BeNumerically("~", 1.0, 1e-9))
+				Expect(idx.Len()).To(Equal(2))
+			})
+
+			It("should not return removed entries", func() {
+				a := &ext_proc.CacheEntry{Prompt: "a", Embedding: []float64{1, 0, 0}}
+				b := &ext_proc.CacheEntry{Prompt: "b", Embedding: []float64{1, 1, 0}}
+				Expect(idx.Add(a)).To(Succeed())
+				Expect(idx.Add(b)).To(Succeed())
+				idx.Remove(a)
+
+				e, _ := idx.Search([]float64{1, 0, 0})
+				Expect(e).To(Equal(b))
+				Expect(idx.Len()).To(Equal(1))
+
+				idx.Remove(b)
+				e, _ = idx.Search([]float64{1, 0, 0})
+				Expect(e).To(BeNil())
+			})
+
+			It("should reject embeddings of another dimension", func() {
+				a := &ext_proc.CacheEntry{Prompt: "a", Embedding: []float64{1, 0, 0}}
+				Expect(idx.Add(a)).To(Succeed())
+				Expect(idx.Add(&ext_proc.CacheEntry{Prompt: "b", Embedding: []float64{1, 0}})).To(MatchError("embedding has 2 dimensions, the index holds 3"))
+				Expect(idx.Len()).To(Equal(1))
+
+				idx.Remove(a)
+				Expect(idx.Add(&ext_proc.CacheEntry{Prompt: "b", Embedding: []float64{1, 0}})).To(Succeed())
+			})
+		})
+	}
+
+	It("should find live entries the hnsw beam misses among tombstones", func() {
+		rng := rand.New(rand.NewSource(42))
+		hnsw := ext_proc.NewHNSWIndex(ext_proc.DefaultHNSWConfig())
+		near := randomEntries(rng, 150, 8)
+		for _, e := range near {
+			e.Embedding[0] += 100
+			Expect(hnsw.Add(e)).To(Succeed())
+		}
+		far := &ext_proc.CacheEntry{Embedding: []float64{0, 1, 0, 0, 0, 0, 0, 0}}
+		Expect(hnsw.Add(far)).To(Succeed())
+		for _, e := range near {
+			hnsw.Remove(e)
+		}
+
+		e, sim := hnsw.Search([]float64{1, 0, 0, 0, 0, 0, 0, 0})
+		Expect(e).To(Equal(far))
+		Expect(sim).To(BeNumerically("~", 0, 1e-9))
+	})
+
+	It("should agree with the flat index on the nearest neighbour", func() {
+		rng := rand.New(rand.NewSource(42))
+		entries := randomEntries(rng, 2000, 32)
+		flat := ext_proc.NewFlatIndex()
+		hnsw := ext_proc.NewHNSWIndex(ext_proc.DefaultHNSWConfig())
+		for _, e := range entries {
+			Expect(flat.Add(e)).To(Succeed())
+			Expect(hnsw.Add(e)).To(Succeed())
+		}
+		// drop a slice of entries so tombstones are exercised too
+		for _, e := range entries[:300] {
+			flat.Remove(e)
+			hnsw.Remove(e)
+		}
+		Expect(hnsw.Len()).To(Equal(flat.Len()))
+
+		hits := 0
+		queries := randomEntries(rng, 100, 32)
+		for _, q := range queries {
+			want, _ := flat.Search(q.Embedding)
+			got, _ := hnsw.Search(q.Embedding)
+			if got == want {
+				hits++
+			}
+		}
+		Expect(hits).To(BeNumerically(">=", 95), "HNSW recall@1 should be at least 95%%")
+	})
+})
@@ -0,0 +1,134 @@
+// Package logging sets up the structured slog logger used across Inferno, and redacts the prompts and
+// generated text written to it.
+package logging
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/kuadrant/inferno/internal/config"
+)
+
+// truncateLength is the number of characters kept of redacted text in truncate mode
+const truncateLength = 32
+
+type redactMode int32
+
+const (
+	redactNone redactMode = iota
+	redactTruncate
+	redactHash
+)
+
+var (
+	level  slog.LevelVar
+	redact atomic.Int32
+)
+
+// Setup installs the configured logger as the slog default, which the log package writes through too
+func Setup(cfg config.LoggingConfig) {
+	slog.SetDefault(slog.New(NewHandler(os.Stderr, cfg.Format)))
+	Reload(cfg)
+}
+
+// Reload applies the level and redaction mode, the format only changes with Setup
+func Reload(cfg config.LoggingConfig) {
+	level.Set(parseLevel(cfg.Level))
+	redact.Store(int32(parseRedact(cfg.Redact)))
+}
+
+// NewHandler returns a text or json handler writing to w at the configured level, adding the attributes
+// carried by the context of each record, see With
+func NewHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: &level}
+	if format == "json" {
+		return contextHandler{slog.NewJSONHandler(w, opts)}
+	}
+	return contextHandler{slog.NewTextHandler(w, opts)}
+}
+
+type attrsKey struct{}
+
+// With returns a context whose records logged with the *Context slog functions carry args, such as the
+// request ID
+func With(ctx context.Context, args ...interface{}) context.Context {
+	prev, _ := ctx.Value(attrsKey{}).([]interface{})
+	return context.WithValue(ctx, attrsKey{}, append(prev[:len(prev):len(prev)], args...))
+}
+
+// contextHandler adds the attributes of With and the trace of the span in the context to records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if args, ok := ctx.Value(attrsKey{}).([]interface{}); ok {
+		r.Add(args...)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+func parseLevel(s string) slog.Level {
+	switch s {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func parseRedact(s string) redactMode {
+	switch s {
+	case "truncate":
+		return redactTruncate
+	case "hash":
+		return redactHash
+	default:
+		return redactNone
+	}
+}
+
+// Text is prompt, completion or body text, logged according to the redaction mode
+type Text string
+
+// LogValue implements slog.LogValuer, so redaction happens only when the record is written
+func (t Text) LogValue() slog.Value {
+	s := string(t)
+	switch redactMode(redact.Load()) {
+	case redactTruncate:
+		n := utf8.RuneCountInString(s)
+		if n <= truncateLength {
+			return slog.StringValue(s)
+		}
+		return slog.StringValue(fmt.Sprintf("%s... (%d chars)", string([]rune(s)[:truncateLength]), n))
+	case redactHash:
+		sum := sha256.Sum256([]byte(s))
+		return slog.StringValue("sha256:" + hex.EncodeToString(sum[:8]))
+	default:
+		return slog.StringValue(s)
+	}
+}
@@ -0,0 +1,92 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/kuadrant/inferno/internal/config"
+	"github.com/kuadrant/inferno/internal/logging"
+)
+
+var _ = Describe("Logging", func() {
+	var out *bytes.Buffer
+	var log *slog.Logger
+
+	// records decodes the JSON records written so far
+	records := func() []map[string]interface{} {
+		var recs []map[string]interface{}
+		dec := json.NewDecoder(out)
+		for dec.More() {
+			rec := map[string]interface{}{}
+			Expect(dec.Decode(&rec)).To(Succeed())
+			recs = append(recs, rec)
+		}
+		return recs
+	}
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		log = slog.New(logging.NewHandler(out, "json"))
+		DeferCleanup(logging.Reload, config.Default().Logging)
+	})
+
+	It("should only write records at or above the level", func() {
+		logging.Reload(config.LoggingConfig{Level: "warn"})
+		log.Info("hidden")
+		log.Warn("shown")
+		Expect(records()).To(ConsistOf(HaveKeyWithValue("msg", "shown")))
+
+		logging.Reload(config.LoggingConfig{Level: "debug"})
+		log.Debug("shown")
+		Expect(records()).To(HaveLen(1))
+	})
+
+	It("should add the context attributes and trace to records", func() {
+		ctx := logging.With(context.Background(), "request_id", "req-1")
+		ctx = trace.ContextWithSpanContext(ctx, trace.NewSpanContext(trace.SpanContextConfig{
+			TraceID: trace.TraceID{1},
+			SpanID:  trace.SpanID{2},
+		}))
+		log.With("component", "test").InfoContext(ctx, "hello")
+		log.Info("without context")
+
+		recs := records()
+		Expect(recs[0]).To(HaveKeyWithValue("request_id", "req-1"))
+		Expect(recs[0]).To(HaveKeyWithValue("component", "test"))
+		Expect(recs[0]).To(HaveKeyWithValue("trace_id", "01000000000000000000000000000000"))
+		Expect(recs[0]).To(HaveKeyWithValue("span_id", "0200000000000000"))
+		Expect(recs[1]).NotTo(HaveKey("request_id"))
+	})
+
+	Describe("Text", func() {
+		prompt := logging.Text("What is Kubernetes? Explain it like I'm five, please.")
+
+		It("should log the full text without redaction", func() {
+			log.Info("prompt", "prompt", prompt)
+			Expect(records()[0]).To(HaveKeyWithValue("prompt", string(prompt)))
+		})
+
+		It("should truncate long text", func() {
+			logging.Reload(config.LoggingConfig{Redact: "truncate"})
+			log.Info("prompt", "prompt", prompt, "short", logging.Text("hello"))
+			rec := records()[0]
+			Expect(rec).To(HaveKeyWithValue("prompt", "What is Kubernetes? Explain it l... (53 chars)"))
+			Expect(rec).To(HaveKeyWithValue("short", "hello"))
+		})
+
+		It("should hash text so equal prompts can still be correlated", func() {
+			logging.Reload(config.LoggingConfig{Redact: "hash"})
+			log.Info("prompt", "prompt", prompt, "again", prompt)
+			Expect(out.String()).NotTo(ContainSubstring("Kubernetes"))
+			rec := records()[0]
+			Expect(rec["prompt"]).To(MatchRegexp(`^sha256:[0-9a-f]{16}$`))
+			Expect(rec["again"]).To(Equal(rec["prompt"]))
+		})
+	})
+})
@@ -0,0 +1,114 @@
+// Package metrics defines the Prometheus metrics exported by Inferno on /metrics.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "inferno"
+
+// Registry holds every Inferno metric along with the Go runtime and process metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	// Tokens counts the tokens reported in upstream responses, type is prompt or completion
+	Tokens = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_total",
+		Help:      "Tokens reported by upstream responses.",
+	}, []string{"model", "route", "type"})
+
+	// CacheLookups counts semantic cache lookups by result: hit, miss or bypass
+	CacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "semantic_cache",
+		Name:      "lookups_total",
+		Help:      "Semantic cache lookups by result.",
+	}, []string{"route", "result"})
+
+	// CacheSimilarity observes the similarity of the closest cached prompt, hits and misses alike
+	CacheSimilarity = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "semantic_cache",
+		Name:      "similarity",
+		Help:      "Similarity of the closest cached prompt found by a lookup.",
+		Buckets:   prometheus.LinearBuckets(0.05, 0.05, 20),
+	})
+
+	// GuardVerdicts counts the verdicts of the guard backends by direction (prompt or response), backend
+	// and verdict (safe or risky)
+	GuardVerdicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "prompt_guard",
+		Name:      "verdicts_total",
+		Help:      "Prompt guard checks by direction, backend and verdict.",
+	}, []string{"route", "direction", "backend", "verdict"})
+
+	// GuardDecisions counts what the guard did with each checked text, including the failure policy
+	// decisions taken when the guardian gave no verdict
+	GuardDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "prompt_guard",
+		Name:      "decisions_total",
+		Help:      "Prompt guard decisions by direction.",
+	}, []string{"route", "direction", "decision"})
+
+	// EmbeddingDuration observes embedding requests, outcome is ok or error
+	EmbeddingDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "embedding",
+		Name:      "request_duration_seconds",
+		Help:      "Duration of embedding requests, including batching.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider", "outcome"})
+
+	// GuardianDuration observes calls to the guardian model, outcome is ok or error
+	GuardianDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "prompt_guard",
+		Name:      "guardian_request_duration_seconds",
+		Help:      "Duration of guardian model calls.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	// PhaseDuration observes the time spent handling each ext_proc message
+	PhaseDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "ext_proc",
+		Name:      "phase_duration_seconds",
+		Help:      "Time spent processing each ext_proc phase.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"phase"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		Tokens,
+		CacheLookups,
+		CacheSimilarity,
+		GuardVerdicts,
+		GuardDecisions,
+		EmbeddingDuration,
+		GuardianDuration,
+		PhaseDuration,
+	)
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Outcome labels an outbound call by its error
+func Outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/kuadrant/inferno/internal/ext_proc"
+)
+
+// healthCheckTimeout bounds a single dependency check
+const healthCheckTimeout = 5 * time.Second
+
+// HealthChecker runs the dependency checks periodically and publishes their status on the gRPC health
+// service, one service per dependency. The overall status, served for "" and the ext_proc service, is
+// SERVING while every dependency is healthy.
+type HealthChecker struct {
+	server   *health.Server
+	checks   map[string]ext_proc.HealthCheck
+	interval time.Duration
+
+	mu       sync.Mutex
+	healthy  map[string]bool
+	ready    bool
+	shutdown bool
+}
+
+// NewHealthChecker returns a checker reporting NOT_SERVING until the first checks have run
+func NewHealthChecker(checks map[string]ext_proc.HealthCheck, interval time.Duration) *HealthChecker {
+	h := &HealthChecker{
+		server:   health.NewServer(),
+		checks:   checks,
+		interval: interval,
+		healthy:  map[string]bool{},
+	}
+	for _, service := range h.services() {
+		h.server.SetServingStatus(service, grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	}
+	return h
+}
+
+// Server returns the gRPC health service, implementing Check and Watch
+func (h *HealthChecker) Server() grpc_health_v1.HealthServer {
+	return h.server
+}
+
+// services returns the overall services followed by the dependency services
+func (h *HealthChecker) services() []string {
+	services := []string{"", extProcPb.ExternalProcessor_ServiceDesc.ServiceName}
+	for name := range h.checks {
+		services = append(services, name)
+	}
+	return services
+}
+
+// Run checks the dependencies now and then every interval until ctx is done
+func (h *HealthChecker) Run(ctx context.Context) {
+	h.check(ctx)
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.check(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// check runs the dependency checks concurrently and updates the served statuses
+func (h *HealthChecker) check(ctx context.Context) {
+	results := make(map[string]error, len(h.checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+			err := check(checkCtx)
+			mu.Lock()
+			results[name] = err
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.shutdown || ctx.Err() != nil {
+		return
+	}
+
+	ready := true
+	for name, err := range results {
+		healthy := err == nil
+		ready = ready && healthy
+		if was, seen := h.healthy[name]; !seen || was != healthy {
+			if healthy {
+				slog.Info("Dependency healthy", "service", name)
+			} else {
+				slog.Warn("Dependency unhealthy", "service", name, "error", err)
+			}
+		}
+		h.healthy[name] = healthy
+		h.server.SetServingStatus(name, servingStatus(healthy))
+	}
+
+	h.ready = ready
+	h.server.SetServingStatus("", servingStatus(ready))
+	h.server.SetServingStatus(extProcPb.ExternalProcessor_ServiceDesc.ServiceName, servingStatus(ready))
+}
+
+// Shutdown reports NOT_SERVING for every service from now on, so load balancers drain the server
+func (h *HealthChecker) Shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.shutdown = true
+	h.ready = false
+	h.server.Shutdown()
+}
+
+// Ready reports whether every dependency passed its last check and the server is not shutting down
+func (h *HealthChecker) Ready() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ready
+}
+
+// Live reports whether the server is not shutting down
+func (h *HealthChecker) Live() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return !h.shutdown
+}
+
+// LivenessHandler serves the /healthz probe, 200 until shutdown
+func (h *HealthChecker) LivenessHandler() http.Handler {
+	return probeHandler(h.Live)
+}
+
+// ReadinessHandler serves the /readyz probe, 200 while Ready
+func (h *HealthChecker) ReadinessHandler() http.Handler {
+	return probeHandler(h.Ready)
+}
+
+func probeHandler(ok func() bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if !ok() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("not ok\n"))
+			return
+		}
+		w.Write([]byte("ok\n"))
+	})
+}
+
+func servingStatus(healthy bool) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	if healthy {
+		return grpc_health_v1.HealthCheckResponse_SERVING
+	}
+	return grpc_health_v1.HealthCheckResponse_NOT_SERVING
+}
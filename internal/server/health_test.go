@@ -0,0 +1,130 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/kuadrant/inferno/internal/ext_proc"
+	"github.com/kuadrant/inferno/internal/server"
+)
+
+var _ = Describe("HealthChecker", func() {
+	var (
+		embeddingDown atomic.Bool
+		health        *server.HealthChecker
+		client        grpc_health_v1.HealthClient
+		ctx           context.Context
+		cancel        context.CancelFunc
+	)
+
+	status := func(service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+		resp, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+		Expect(err).NotTo(HaveOccurred())
+		return resp.Status
+	}
+
+	probe := func(h http.Handler) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+
+	BeforeEach(func() {
+		embeddingDown.Store(true)
+		health = server.NewHealthChecker(map[string]ext_proc.HealthCheck{
+			ext_proc.HealthEmbedding: func(ctx context.Context) error {
+				if embeddingDown.Load() {
+					return errors.New("embedding backend unavailable")
+				}
+				return nil
+			},
+			ext_proc.HealthCacheStore: func(ctx context.Context) error { return nil },
+		}, 10*time.Millisecond)
+
+		lis, err := net.Listen("tcp", "localhost:0")
+		Expect(err).NotTo(HaveOccurred())
+		grpcServer := grpc.NewServer()
+		grpc_health_v1.RegisterHealthServer(grpcServer, health.Server())
+		go grpcServer.Serve(lis)
+		DeferCleanup(grpcServer.Stop)
+
+		conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(conn.Close)
+		client = grpc_health_v1.NewHealthClient(conn)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		DeferCleanup(cancel)
+	})
+
+	It("reports NOT_SERVING before the first checks", func() {
+		Expect(status("")).To(Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+		Expect(health.Ready()).To(BeFalse())
+	})
+
+	It("reports each dependency and ties the overall status to all of them", func() {
+		go health.Run(ctx)
+
+		Eventually(func() grpc_health_v1.HealthCheckResponse_ServingStatus {
+			return status(ext_proc.HealthCacheStore)
+		}).Should(Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+		Expect(status(ext_proc.HealthEmbedding)).To(Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+		Expect(status("")).To(Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+		Expect(probe(health.ReadinessHandler())).To(Equal(http.StatusServiceUnavailable))
+		Expect(probe(health.LivenessHandler())).To(Equal(http.StatusOK))
+
+		embeddingDown.Store(false)
+		Eventually(func() grpc_health_v1.HealthCheckResponse_ServingStatus {
+			return status("")
+		}).Should(Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+		Expect(status(extProcPb.ExternalProcessor_ServiceDesc.ServiceName)).To(Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+		Expect(status(ext_proc.HealthEmbedding)).To(Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+		Expect(probe(health.ReadinessHandler())).To(Equal(http.StatusOK))
+	})
+
+	It("answers NotFound for unknown services", func() {
+		_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: "inferno.unknown"})
+		Expect(err).To(MatchError(ContainSubstring("NotFound")))
+	})
+
+	It("streams status changes to watchers, and NOT_SERVING on shutdown", func() {
+		embeddingDown.Store(false)
+		stream, err := client.Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+		Expect(err).NotTo(HaveOccurred())
+
+		resp, err := stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Status).To(Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+
+		go health.Run(ctx)
+
+		resp, err = stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Status).To(Equal(grpc_health_v1.HealthCheckResponse_SERVING))
+
+		health.Shutdown()
+		resp, err = stream.Recv()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Status).To(Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+		Expect(status(ext_proc.HealthCacheStore)).To(Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+		Expect(probe(health.LivenessHandler())).To(Equal(http.StatusServiceUnavailable))
+		Expect(probe(health.ReadinessHandler())).To(Equal(http.StatusServiceUnavailable))
+
+		// later checks don't flip the status back
+		Consistently(func() grpc_health_v1.HealthCheckResponse_ServingStatus {
+			return status("")
+		}, 50*time.Millisecond).Should(Equal(grpc_health_v1.HealthCheckResponse_NOT_SERVING))
+	})
+})
@@ -2,40 +2,31 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	extProcPb "github.com/envoyproxy/go-control-plane/envoy/service/ext_proc/v3"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/health/grpc_health_v1"
 
+	"github.com/kuadrant/inferno/internal/config"
 	"github.com/kuadrant/inferno/internal/ext_proc"
+	"github.com/kuadrant/inferno/internal/metrics"
+	"github.com/kuadrant/inferno/internal/tracing"
 )
 
-type HealthServer struct {
-	grpc_health_v1.UnimplementedHealthServer
-}
-
-func (h *HealthServer) Check(ctx context.Context, in *grpc_health_v1.HealthCheckRequest) (*grpc_health_v1.HealthCheckResponse, error) {
-	return &grpc_health_v1.HealthCheckResponse{Status: grpc_health_v1.HealthCheckResponse_SERVING}, nil
-}
-
-func (h *HealthServer) Watch(in *grpc_health_v1.HealthCheckRequest, srv grpc_health_v1.Health_WatchServer) error {
-	return fmt.Errorf("watch is not implemented")
-}
-
 type Server struct {
-	config *Config
+	config *config.Watcher
 }
 
-func NewServer(cfg *Config) *Server {
-	if cfg == nil {
-		cfg = DefaultConfig()
-	}
+func NewServer(cfg *config.Watcher) *Server {
 	return &Server{
 		config: cfg,
 	}
@@ -45,39 +36,124 @@ func (s *Server) Run() error {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
 
+	shutdownTracing, err := tracing.Setup(ctx)
+	if err != nil {
+		slog.Error("Tracing setup error", "error", err)
+		return err
+	}
+	defer func() {
+		// flush the spans of the last requests
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			slog.Error("Tracing shutdown error", "error", err)
+		}
+	}()
+
+	cfg := s.config.Current()
+	processor := ext_proc.NewProcessorWithConfig(cfg)
+	defer processor.Close()
+
+	health := NewHealthChecker(processor.HealthChecks(), time.Duration(cfg.Server.HealthCheckInterval))
+	go health.Run(ctx)
+
+	if port := cfg.Server.MetricsPort; port > 0 {
+		if err := s.startMetricsServer(ctx, port, health); err != nil {
+			slog.Error("Metrics server error", "error", err)
+			return err
+		}
+	}
+
 	// Start the processor server
-	if err := s.startProcessorServer(ctx); err != nil {
-		log.Printf("Processor server error: %v", err)
+	if err := s.startProcessorServer(ctx, processor, health); err != nil {
+		slog.Error("Processor server error", "error", err)
 		return err
 	}
 
 	return nil
 }
 
-func (s *Server) startProcessorServer(ctx context.Context) error {
-	port := s.config.ExtProcPort
+// startMetricsServer serves Prometheus metrics on /metrics and the /healthz and /readyz probes until ctx
+// is done
+func (s *Server) startMetricsServer(ctx context.Context, port int, health *HealthChecker) error {
+	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on port %d: %v", port, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.Handle("/healthz", health.LivenessHandler())
+	mux.Handle("/readyz", health.ReadinessHandler())
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	slog.Info("Metrics server listening", "port", port)
+
+	go func() {
+		if err := httpServer.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server error", "error", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdownCtx)
+	}()
+	return nil
+}
+
+func (s *Server) startProcessorServer(ctx context.Context, processor *ext_proc.Processor, health *HealthChecker) error {
+	port := s.config.Current().Server.ExtProcPort
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return fmt.Errorf("failed to listen on port %d: %v", port, err)
 	}
 
 	grpcServer := grpc.NewServer()
-	processor := ext_proc.NewProcessor()
 	extProcPb.RegisterExternalProcessorServer(grpcServer, processor)
-	grpc_health_v1.RegisterHealthServer(grpcServer, &HealthServer{})
+	grpc_health_v1.RegisterHealthServer(grpcServer, health.Server())
 
-	log.Printf("Ext_proc server listening on :%d", port)
+	slog.Info("Ext_proc server listening", "port", port)
+
+	// Reloaded configuration applies to new requests, streams in flight are not interrupted
+	s.config.OnChange(processor.Reload)
+	go s.config.Run(ctx)
 
 	// Start server in a goroutine
 	go func() {
 		if err := grpcServer.Serve(lis); err != nil {
-			log.Printf("Processor server error: %v", err)
+			slog.Error("Processor server error", "error", err)
 		}
 	}()
 
 	// Wait for context cancellation
 	<-ctx.Done()
-	log.Println("Shutting down processor server")
-	grpcServer.GracefulStop()
+	slog.Info("Shutting down processor server")
+	// Report NOT_SERVING first, so health checking clients stop sending new streams while in flight
+	// ones finish
+	health.Shutdown()
+	if !stopGracefully(grpcServer, time.Duration(s.config.Current().Server.ShutdownTimeout)) {
+		slog.Warn("Streams still open after the shutdown timeout, closing them")
+	}
 	return nil
 }
+
+// stopGracefully lets streams in flight finish for up to timeout, then closes them. Health Watch streams
+// and idle ext_proc streams only end with their client, so GracefulStop alone may never return.
+// It reports whether every stream finished in time.
+func stopGracefully(grpcServer *grpc.Server, timeout time.Duration) bool {
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return true
+	case <-time.After(timeout):
+		grpcServer.Stop()
+		<-stopped
+		return false
+	}
+}
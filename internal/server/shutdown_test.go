@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var _ = Describe("stopGracefully", func() {
+	It("should close streams still open after the timeout", func() {
+		health := NewHealthChecker(nil, time.Minute)
+		lis, err := net.Listen("tcp", "localhost:0")
+		Expect(err).NotTo(HaveOccurred())
+		grpcServer := grpc.NewServer()
+		grpc_health_v1.RegisterHealthServer(grpcServer, health.Server())
+		go grpcServer.Serve(lis)
+
+		conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(conn.Close)
+		watch, err := grpc_health_v1.NewHealthClient(conn).Watch(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+		Expect(err).NotTo(HaveOccurred())
+		_, err = watch.Recv()
+		Expect(err).NotTo(HaveOccurred())
+
+		health.Shutdown()
+		start := time.Now()
+		Expect(stopGracefully(grpcServer, 100*time.Millisecond)).To(BeFalse())
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+		Eventually(func() error {
+			_, err := watch.Recv()
+			return err
+		}).Should(HaveOccurred())
+	})
+
+	It("should report streams that finished in time", func() {
+		grpcServer := grpc.NewServer()
+		Expect(stopGracefully(grpcServer, time.Second)).To(BeTrue())
+	})
+})
@@ -0,0 +1,75 @@
+package tracing_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	collectorPb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/kuadrant/inferno/internal/tracing"
+)
+
+var _ = Describe("Setup", func() {
+	BeforeEach(func() {
+		tp, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+		DeferCleanup(func() {
+			otel.SetTracerProvider(tp)
+			otel.SetTextMapPropagator(propagator)
+		})
+		for _, name := range []string{"OTEL_SDK_DISABLED", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_PROTOCOL", "OTEL_EXPORTER_OTLP_TRACES_PROTOCOL", "OTEL_SERVICE_NAME"} {
+			GinkgoT().Setenv(name, "")
+		}
+	})
+
+	It("should export spans to the OTLP endpoint", func() {
+		received := make(chan *collectorPb.ExportTraceServiceRequest, 1)
+		collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.URL.Path).To(Equal("/v1/traces"))
+			body, err := io.ReadAll(r.Body)
+			Expect(err).NotTo(HaveOccurred())
+			req := &collectorPb.ExportTraceServiceRequest{}
+			Expect(proto.Unmarshal(body, req)).To(Succeed())
+			received <- req
+			w.Header().Set("Content-Type", "application/x-protobuf")
+		}))
+		DeferCleanup(collector.Close)
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL)
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/protobuf")
+
+		shutdown, err := tracing.Setup(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		_, span := tracing.Tracer().Start(context.Background(), "test")
+		span.End()
+		Expect(shutdown(context.Background())).To(Succeed())
+
+		var req *collectorPb.ExportTraceServiceRequest
+		Eventually(received).Should(Receive(&req))
+		rs := req.GetResourceSpans()[0]
+		Expect(rs.GetResource().GetAttributes()).To(ContainElement(HaveField("Value.GetStringValue()", "inferno")))
+		Expect(rs.GetScopeSpans()[0].GetSpans()[0].GetName()).To(Equal("test"))
+	})
+
+	It("should only install the propagator without an endpoint", func() {
+		shutdown, err := tracing.Setup(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(shutdown(context.Background())).To(Succeed())
+		Expect(otel.GetTextMapPropagator().Fields()).To(ContainElement("traceparent"))
+
+		_, span := tracing.Tracer().Start(context.Background(), "test")
+		Expect(span.SpanContext().IsValid()).To(BeFalse())
+	})
+
+	It("should reject an unknown protocol", func() {
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318")
+		GinkgoT().Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/json")
+		_, err := tracing.Setup(context.Background())
+		Expect(err).To(MatchError(ContainSubstring("unsupported OTLP protocol")))
+	})
+})
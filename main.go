@@ -1,26 +1,34 @@
 package main
 
 import (
-	"log"
+	"flag"
+	"log/slog"
 	"os"
-	"strconv"
 
+	"github.com/kuadrant/inferno/internal/config"
+	"github.com/kuadrant/inferno/internal/logging"
 	"github.com/kuadrant/inferno/internal/server"
 )
 
 func main() {
-	cfg := server.DefaultConfig()
+	configPath := flag.String("config", os.Getenv("INFERNO_CONFIG"), "path to a YAML or JSON configuration file")
+	flag.Parse()
 
-	if port := os.Getenv("EXT_PROC_PORT"); port != "" {
-		if p, err := strconv.Atoi(port); err == nil {
-			cfg.ExtProcPort = p
-		}
+	cfg, err := config.NewWatcher(*configPath)
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(1)
 	}
 
+	// the level and redaction follow reloads, the format is fixed at startup
+	logging.Setup(cfg.Current().Logging)
+	cfg.OnChange(func(c *config.Config) { logging.Reload(c.Logging) })
+
 	srv := server.NewServer(cfg)
-	log.Println("Starting Inferno ext_proc service")
+	slog.Info("Starting Inferno ext_proc service")
 
 	if err := srv.Run(); err != nil {
-		log.Fatalf("Server error: %v", err)
+		slog.Error("Server error", "error", err)
+		os.Exit(1)
 	}
 }